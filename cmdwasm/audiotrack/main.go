@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bufio"
-	"encoding/gob"
+	"context"
 	"errors"
 	"io"
-	"net/textproto"
 	"sync"
 	"syscall/js"
 	"time"
@@ -16,10 +14,8 @@ import (
 	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
 )
 
-func generateChunks(w io.Writer, _ io.Reader) error {
+func generateChunks(ctx context.Context, _ <-chan struct{}, out chan<- audio.Chunk) error {
 	jsutil.ConsoleLog("2. worker")
-	writer := textproto.NewWriter(bufio.NewWriter(w))
-	defer writer.W.Flush()
 
 	// Currently the wav decoder requires the entire file to be downloaded before it can start producing chunks.
 	// chunks := audio.GetWavChunks(wavURL, chunkSize)
@@ -31,11 +27,12 @@ func generateChunks(w io.Writer, _ io.Reader) error {
 	chunkDuration := time.Duration(dur)
 	jsutil.ConsoleLog("Chunk duration:", chunkDuration.String())
 
-	dw := writer.DotWriter()
-	defer dw.Close()
-
 	for chunk := range chunks {
-		mustWriteChunk(dw, chunk)
+		select {
+		case out <- chunk:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		//	_ = tb
 		// TODO time.Sleep takes a lot of resources.
 		// Block if necessary to to stay ahead only buffer duration.
@@ -45,53 +42,69 @@ func generateChunks(w io.Writer, _ io.Reader) error {
 	return nil
 }
 
-func applyGain(w io.Writer, r io.Reader) error {
+func applyGain(ctx context.Context, in <-chan audio.Chunk, out chan<- audio.Chunk) error {
 	jsutil.ConsoleLog("3. worker")
-	reader := textproto.NewReader(bufio.NewReader(r))
-	writer := textproto.NewWriter(bufio.NewWriter(w))
-	defer writer.W.Flush()
-
-	dw := writer.DotWriter()
-	defer dw.Close()
 
-	dr := reader.DotReader()
+	var gain float32
+	md, _ := wrpc.MetadataFromContext(ctx)
+	if err := md.Arg(0, &gain); err != nil {
+		return err
+	}
 
-	forEachChunk(dr, func(chunk audio.Chunk) {
+	for chunk := range in {
 		// Apply gain FX.
-		audio.Gain(&chunk, 0.5)
-		mustWriteChunk(dw, chunk)
-	})
+		audio.Gain(&chunk, gain)
+		out <- chunk
+	}
 
 	return nil
 }
 
-func audioSource(w io.Writer, _ io.Reader) error {
+func audioSource(ctx context.Context, _ <-chan struct{}, out chan<- audio.Chunk) error {
 	jsutil.ConsoleLog("1. worker")
-	rr, _ := wrpc.Call("generateChunks", "applyGain")
-	if _, err := io.Copy(w, rr); err != nil {
-		panic(err)
-	}
 
-	return nil
+	// Apply FX on multiple workers, keeping the order of chunks.
+	g := wrpc.NewGraph()
+	gen := g.Node(wrpc.Fn("generateChunks"))
+	g.Parallel(wrpc.Parallel{N: 2, Ordered: true}, wrpc.Fn("applyGain", 0.5)).From(gen)
+
+	chunks, send := wrpc.RunGraph[struct{}, audio.Chunk](ctx, g, wrpc.Gob)
+	send.Close()
+
+	for {
+		chunk, err := chunks.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		out <- chunk
+	}
 }
 
-func passThrough(w io.Writer, r io.Reader) error {
+func passThrough(_ context.Context, in <-chan audio.Chunk, out chan<- audio.Chunk) error {
 	jsutil.ConsoleLog("4. worker")
-	if n, err := io.Copy(w, r); err != nil {
-		panic(err)
-	} else if n == 0 {
-		panic("0 copy")
 
+	n := 0
+	for chunk := range in {
+		out <- chunk
+		n++
 	}
+
+	if n == 0 {
+		return errors.New("0 chunks")
+	}
+
 	return nil
 }
 
 func main() {
 	if jsutil.IsWorker() {
-		wrpc.Register("generateChunks", generateChunks)
-		wrpc.Register("applyGain", applyGain)
-		wrpc.Register("audioSource", audioSource)
-		wrpc.Register("passThrough", passThrough)
+		wrpc.RegisterStream("generateChunks", generateChunks)
+		wrpc.RegisterStream("applyGain", applyGain)
+		wrpc.RegisterStream("audioSource", audioSource)
+		wrpc.RegisterStream("passThrough", passThrough)
 
 		if err := wrpc.ListenAndServe(); err != nil {
 			panic(err)
@@ -121,41 +134,27 @@ func browser() {
 	<-done
 }
 
-func forEachChunk(r io.Reader, cb func(audio.Chunk)) {
-	for {
-		var chunk audio.Chunk
-		d := gob.NewDecoder(r)
-		if err := d.Decode(&chunk); err != nil {
-			if errors.Is(err, io.EOF) {
-				return
-			}
-			panic(err)
-		}
-		cb(chunk)
-	}
-}
-
-func mustWriteChunk(w io.Writer, chunk audio.Chunk) {
-	e := gob.NewEncoder(w)
-	if err := e.Encode(chunk); err != nil {
-		panic(err)
-	}
-}
-
 const (
 	chunkSize      = 4 * 1024
 	bufferDuration = 200 * time.Millisecond
 )
 
 func runAudio() {
-	// Master track reader.
-	r, _ := wrpc.Call("audioSource", "passThrough")
-	dr := textproto.NewReader(bufio.NewReader(r)).DotReader()
+	// Master track stream.
+	chunks, _ := wrpc.CallStream[struct{}, audio.Chunk](context.Background(), wrpc.Gob, wrpc.Fn("audioSource"), wrpc.Fn("passThrough"))
 
 	audioCtx := js.Global().Get("AudioContext").New()
 	player := js.Global().Get("PCMPlayer").New(audioCtx)
 
-	forEachChunk(dr, func(chunk audio.Chunk) {
+	for {
+		chunk, err := chunks.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			panic(err)
+		}
+
 		// TODO: It didn't make a difference if I sent
 		// the channels together or separately.
 		// Should rather try an URL object approach for some MIME type.
@@ -171,5 +170,5 @@ func runAudio() {
 		arrRight := array.NewFromSlice(right)
 
 		player.Call("playNext", arrLeft.Value, arrRight.Value)
-	})
+	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,12 +10,13 @@ import (
 
 	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
 	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
 )
 
 func main() {
 	if jsutil.IsWorker() {
-		wrpc.Register("call", func(io.Writer, io.Reader) error { return nil })
-		wrpc.Register("echoBytes", func(w io.Writer, r io.Reader) error {
+		wrpc.Register("call", func(context.Context, io.Writer, io.Reader) error { return nil })
+		wrpc.Register("echoBytes", func(_ context.Context, w io.Writer, r io.Reader) error {
 			if _, err := io.Copy(w, r); err != nil {
 				panic(fmt.Errorf("echoBytes handler: %w", err))
 			}
@@ -42,40 +44,27 @@ func (byteGenerator) Read(b []byte) (int, error) {
 func browser() {
 	defer jsutil.ConsoleLog("Exiting main program")
 
-	jsutil.ConsoleLog("running echoBytes benchmark")
-
-	initialSize := 1 * 1024
-	maxSize := 1024 * 1024
-	dur := 2 * time.Second
+	jsutil.ConsoleLog("running worker startup benchmark")
+	benchmarkStartup("compiled in worker", wrpc.WorkerConfig{CompileInWorker: true})
+	benchmarkStartup("shared module", wrpc.WorkerConfig{})
 
-	for size := initialSize; size <= maxSize; size *= 2 {
-		payload := bytes.Repeat([]byte{1}, size)
-
-		r, w := wrpc.Call("echoBytes")
-
-		go func() {
-			start := time.Now()
-
-			for time.Since(start) < dur {
-				if n, err := w.Write(payload); err != nil {
-					panic(err)
-				} else if n != size {
-					panic(io.ErrShortWrite)
-				}
-			}
-
-			if err := w.Close(); err != nil {
-				panic(err)
-			}
-		}()
-
-		b, err := ioutil.ReadAll(r)
-		if err != nil {
-			panic(err)
-		}
-		mps := (float64(len(b)) / dur.Seconds()) / 1024 / 1024
-
-		jsutil.ConsoleLog("echoBytes %dK: MB/s:", size/1024, mps)
+	jsutil.ConsoleLog("running echoBytes benchmark")
+	benchmarkEchoBytes(wrpc.DefaultPool)
+
+	jsutil.ConsoleLog("running echoBytes benchmark with a flow control window")
+	pool := wrpc.NewPool(wrpc.PoolConfig{
+		Window: wrpcnet.Window{Messages: 16, Bytes: 4 * 1024 * 1024},
+	})
+	defer pool.Close()
+	benchmarkEchoBytes(pool)
+
+	if wrpcnet.SharedMemoryAvailable() {
+		jsutil.ConsoleLog("running echoBytes benchmark with shared memory")
+		sharedPool := wrpc.NewPool(wrpc.PoolConfig{
+			Window: wrpcnet.Window{Bytes: 4 * 1024 * 1024, Shared: true},
+		})
+		defer sharedPool.Close()
+		benchmarkEchoBytes(sharedPool)
 	}
 
 	// jsutil.ConsoleLog("running call benchmark")
@@ -114,3 +103,50 @@ func browser() {
 
 	jsutil.ConsoleLog("benchmark done")
 }
+
+func benchmarkStartup(name string, config wrpc.WorkerConfig) {
+	pool := wrpc.NewPool(wrpc.PoolConfig{MinWorkers: 8, Worker: config})
+	defer pool.Close()
+
+	if err := pool.WarmUp(context.Background()); err != nil {
+		panic(err)
+	}
+
+	jsutil.ConsoleLog(fmt.Sprintf("startup %s: mean %s", name, pool.Stats().StartupTime))
+}
+
+func benchmarkEchoBytes(pool *wrpc.Pool) {
+	initialSize := 1 * 1024
+	maxSize := 1024 * 1024
+	dur := 2 * time.Second
+
+	for size := initialSize; size <= maxSize; size *= 2 {
+		payload := bytes.Repeat([]byte{1}, size)
+
+		r, w := pool.Call(wrpc.Fn("echoBytes"))
+
+		go func() {
+			start := time.Now()
+
+			for time.Since(start) < dur {
+				if n, err := w.Write(payload); err != nil {
+					panic(err)
+				} else if n != size {
+					panic(io.ErrShortWrite)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				panic(err)
+			}
+		}()
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			panic(err)
+		}
+		mps := (float64(len(b)) / dur.Seconds()) / 1024 / 1024
+
+		jsutil.ConsoleLog("echoBytes %dK: MB/s:", size/1024, mps)
+	}
+}
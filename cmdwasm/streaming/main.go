@@ -2,7 +2,7 @@ package main
 
 import (
 	"bufio"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"io"
 	"math/rand"
@@ -26,13 +26,13 @@ func main() {
 	}
 }
 
-func stringGeneratorWorker(w io.Writer, r io.Reader) error {
+func stringGeneratorWorker(ctx context.Context, w io.Writer, _ io.Reader) error {
 	fmt.Println("stated stringGeneratorWorker")
 
 	// decode args
-	dec := gob.NewDecoder(r)
 	var n int
-	if err := dec.Decode(&n); err != nil && err != io.EOF {
+	md, _ := wrpc.MetadataFromContext(ctx)
+	if err := md.Arg(0, &n); err != nil {
 		return err
 	}
 
@@ -51,7 +51,7 @@ func stringGeneratorWorker(w io.Writer, r io.Reader) error {
 	return bufOut.Flush()
 }
 
-func upperCaseWorker(w io.Writer, r io.Reader) error {
+func upperCaseWorker(_ context.Context, w io.Writer, r io.Reader) error {
 	fmt.Println("started upperCaseWorker")
 
 	scanner := bufio.NewScanner(r)
@@ -79,7 +79,7 @@ func rev(s string) string {
 	return string(runes)
 }
 
-func reverseWorker(w io.Writer, r io.Reader) error {
+func reverseWorker(_ context.Context, w io.Writer, r io.Reader) error {
 	fmt.Println("started reverseWorker")
 
 	scanner := bufio.NewScanner(r)
@@ -106,16 +106,12 @@ func reverseWorker(w io.Writer, r io.Reader) error {
 func browser() {
 	defer jsutil.ConsoleLog("Exiting main program")
 
-	// Schedule 3 workers to start streaming
-	r, w := wrpc.Call("stringGeneratorWorker", "upperCaseWorker", "reverseWorker")
-
-	// Specify the count of strings to be generated.
-	enc := gob.NewEncoder(w)
-	// Generate 10 strings
-	count := 10
-	if err := enc.Encode(&count); err != nil {
-		panic(err)
-	}
+	// Schedule 3 workers to start streaming, generating 10 strings.
+	r, w := wrpc.Call(
+		wrpc.Fn("stringGeneratorWorker", 10),
+		wrpc.Fn("upperCaseWorker"),
+		wrpc.Fn("reverseWorker"),
+	)
 
 	if err := w.Close(); err != nil {
 		panic(err)
@@ -2,12 +2,56 @@
 package jsutil
 
 import (
+	"errors"
+	"sync"
 	"syscall/js"
 )
 
-// IsWorker returns whether the program is running in a webworker.
+// IsWorker returns whether the program is running in a webworker
+// or in a Node.js worker thread.
 func IsWorker() bool {
-	return js.Global().Get("WorkerGlobalScope").Type() != js.TypeUndefined
+	if js.Global().Get("WorkerGlobalScope").Type() != js.TypeUndefined {
+		return true
+	}
+
+	wt := WorkerThreads()
+	return !wt.IsUndefined() && !wt.Get("isMainThread").Bool()
+}
+
+// IsNode returns whether the program is running on Node.js.
+func IsNode() bool {
+	// wasm_exec.js defines a process stub in browsers.
+	versions := js.Global().Get("process").Get("versions")
+	return versions.Type() == js.TypeObject && versions.Get("node").Type() == js.TypeString
+}
+
+// WorkerThreads returns the Node.js worker_threads module, or undefined in browsers.
+func WorkerThreads() js.Value {
+	workerThreadsOnce.Do(func() {
+		workerThreads = js.Undefined()
+
+		// wasm_exec_node.js exposes require.
+		if require := js.Global().Get("require"); IsNode() && require.Type() == js.TypeFunction {
+			workerThreads = require.Invoke("worker_threads")
+		}
+	})
+
+	return workerThreads
+}
+
+var (
+	workerThreadsOnce sync.Once
+	workerThreads     js.Value
+)
+
+// ParentPort returns the object a worker exchanges messages with its parent through:
+// the worker global scope in browsers and worker_threads.parentPort on Node.js.
+func ParentPort() js.Value {
+	if wt := WorkerThreads(); !wt.IsUndefined() && !wt.Get("isMainThread").Bool() {
+		return wt.Get("parentPort")
+	}
+
+	return js.Global()
 }
 
 // CreateURLObject creates an url object.
@@ -16,6 +60,38 @@ func CreateURLObject(data any, mime string) js.Value {
 	return js.Global().Get("URL").Call("createObjectURL", blob)
 }
 
+// Await waits for a promise to settle and returns its value.
+// A rejection is returned as an error.
+func Await(promise js.Value) (js.Value, error) {
+	type result struct {
+		value js.Value
+		err   error
+	}
+
+	ch := make(chan result, 1)
+
+	onFulfilled := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		ch <- result{value: args[0]}
+		return nil
+	})
+	defer onFulfilled.Release()
+
+	onRejected := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		if reason := args[0]; reason.Type() == js.TypeObject {
+			ch <- result{err: js.Error{Value: reason}}
+		} else {
+			ch <- result{err: errors.New(reason.String())}
+		}
+		return nil
+	})
+	defer onRejected.Release()
+
+	promise.Call("then", onFulfilled, onRejected)
+
+	r := <-ch
+	return r.value, r.err
+}
+
 var console = js.Global().Get("console")
 
 // ConsoleLog console.log
@@ -1,20 +1,51 @@
 package wrpc
 
 import (
+	"context"
+	"errors"
 	"io"
+	"sort"
 	"sync"
 
 	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
 )
 
+// ErrFuncNotFound is returned when the remote function is not registered on the worker.
+var ErrFuncNotFound = errors.New("wrpc: remote func not found")
+
+// ErrWorkerClosed is returned from calls on a closed worker.
+var ErrWorkerClosed = errors.New("wrpc: worker closed")
+
+// ErrWorkerCrashed is returned from calls on a worker that raised an uncaught error,
+// such as a worker whose Go program exited after a panic.
+var ErrWorkerCrashed = errors.New("wrpc: worker crashed")
+
 // HandlerFunc is a remote function.
-type HandlerFunc func(io.Writer, io.Reader) error
+//
+// The context is cancelled when the caller cancels the call.
+type HandlerFunc func(context.Context, io.Writer, io.Reader) error
+
+// FuncInfo describes a function registered on a worker.
+type FuncInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
 
 // Register registers a remote function.
 func Register(name string, f HandlerFunc) {
 	funcs[name] = f
 }
 
+// SetVersion sets the version of a remote function reported by Funcs.
+func SetVersion(name, version string) {
+	versions[name] = version
+}
+
+// Funcs returns the functions registered on the workers of DefaultPool, sorted by name.
+func Funcs(ctx context.Context) ([]FuncInfo, error) {
+	return DefaultPool.Funcs(ctx)
+}
+
 // Call executes functions by chaining them and piping each function's output into the next.
 //
 // The returned WriteCloser is piped to the first func's Reader and
@@ -22,38 +53,157 @@ func Register(name string, f HandlerFunc) {
 //
 // The returned Reader returns the first error from any function
 // or io.EOF when all functions finish.
-func Call(names ...string) (io.Reader, io.WriteCloser) {
-	remoteReader, localWriter := wrpcnet.Pipe()
+//
+// If a worker cannot be spawned, a call cannot be dispatched or a function
+// is not registered on the worker, the whole chain is cancelled and
+// the error is returned from both the Reader and the WriteCloser.
+//
+// Call runs the functions on DefaultPool.
+func Call(fns ...Func) (io.Reader, io.WriteCloser) {
+	return DefaultPool.Call(fns...)
+}
 
-	for _, name := range names {
-		p1, p2 := wrpcnet.Pipe()
+// CallContext is like Call but the call chain can be cancelled with ctx.
+//
+// When ctx is cancelled, every function in the chain observes the cancellation
+// through its context and the returned Reader and WriteCloser return ctx.Err().
+func CallContext(ctx context.Context, fns ...Func) (io.Reader, io.WriteCloser) {
+	return DefaultPool.CallContext(ctx, fns...)
+}
 
-		w := p1
-		r := remoteReader
-		name := name
+// Call is like the package-level Call but runs the functions on workers from p.
+func (p *Pool) Call(fns ...Func) (io.Reader, io.WriteCloser) {
+	return p.CallContext(context.Background(), fns...)
+}
 
-		worker := pool.Get().(*Worker)
-		go func() {
-			if err := worker.Call(w, r, name); err != nil {
-				panic(err)
-			}
-			pool.Put(worker)
-		}()
+// CallContext is like the package-level CallContext but runs the functions on workers from p.
+func (p *Pool) CallContext(ctx context.Context, fns ...Func) (io.Reader, io.WriteCloser) {
+	return p.call(ctx, fns...)
+}
+
+// call runs the chain and returns the local ends of the chain's pipes.
+func (p *Pool) call(ctx context.Context, fns ...Func) (*wrpcnet.MessagePort, *wrpcnet.MessagePort) {
+	return p.callReserved(ctx, func(ctx context.Context) ([]*Worker, error) {
+		return p.reserve(ctx, len(fns))
+	}, fns...)
+}
 
-		remoteReader = p2
+// callReserved is like call but runs the functions on the workers returned by reserve,
+// one for each function.
+func (p *Pool) callReserved(ctx context.Context, reserve func(context.Context) ([]*Worker, error), fns ...Func) (*wrpcnet.MessagePort, *wrpcnet.MessagePort) {
+	type stage struct {
+		fn   Func
+		w, r *wrpcnet.MessagePort
 	}
 
-	return remoteReader, localWriter
-}
+	r, localWriter := wrpcnet.Pipe()
+	p.configurePipe(localWriter)
+
+	stages := make([]stage, len(fns))
+	for i, fn := range fns {
+		w, next := wrpcnet.Pipe()
+		p.configurePipe(w)
+		stages[i] = stage{fn: fn, w: w, r: r}
+		r = next
+	}
+
+	remoteReader := r
+
+	ctx, cancel := context.WithCancel(ctx)
 
-var pool = sync.Pool{
-	New: func() any {
-		worker, err := NewWorker("index.js")
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			localWriter.Abort(err)
+			remoteReader.Abort(err)
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(stages))
+
+	go func() {
+		workers, err := reserve(ctx)
 		if err != nil {
-			panic(err)
+			for _, s := range stages {
+				s.w.Abort(err)
+				s.r.Abort(err)
+				wg.Done()
+			}
+			fail(err)
+			return
 		}
-		return worker
-	},
+
+		for i, s := range stages {
+			s, worker := s, workers[i]
+
+			go func() {
+				defer wg.Done()
+
+				err := worker.Call(ctx, s.w, s.r, s.fn)
+				if reusable(err) {
+					p.put(worker)
+				} else {
+					p.discard(worker)
+				}
+
+				if err != nil {
+					fail(err)
+				}
+			}()
+		}
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	go func() {
+		defer cancel()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			fail(ctx.Err())
+		}
+	}()
+
+	return remoteReader, localWriter
+}
+
+// configurePipe sets the pool's flow control window and encoding on a writer.
+func (p *Pool) configurePipe(w *wrpcnet.MessagePort) {
+	if p.config.Window != (wrpcnet.Window{}) {
+		w.SetWindow(p.config.Window)
+	}
+	w.SetEncoding(p.config.Encoding)
+}
+
+// reusable reports whether a worker can be reused after a call returned err.
+func reusable(err error) bool {
+	return err == nil ||
+		errors.Is(err, ErrFuncNotFound) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
 }
 
-var funcs = map[string]HandlerFunc{}
+var (
+	funcs    = map[string]HandlerFunc{}
+	versions = map[string]string{}
+)
+
+// registeredFuncs returns the registered functions sorted by name.
+func registeredFuncs() []FuncInfo {
+	infos := make([]FuncInfo, 0, len(funcs))
+	for name := range funcs {
+		infos = append(infos, FuncInfo{Name: name, Version: versions[name]})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
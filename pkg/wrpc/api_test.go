@@ -0,0 +1,245 @@
+package wrpc_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	wrpc.Register("repeat", func(ctx context.Context, w io.Writer, _ io.Reader) error {
+		var s string
+		var n int
+		md, _ := wrpc.MetadataFromContext(ctx)
+		if err := md.Arg(0, &s); err != nil {
+			return err
+		}
+		if err := md.Arg(1, &n); err != nil {
+			return err
+		}
+
+		for i := 0; i < n; i++ {
+			if _, err := io.WriteString(w, s+"\n"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	wrpc.Register("upper", func(_ context.Context, w io.Writer, r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if _, err := io.WriteString(w, strings.ToUpper(scanner.Text())+"\n"); err != nil {
+				return err
+			}
+		}
+
+		return scanner.Err()
+	})
+
+	wrpc.Register("fail", func(context.Context, io.Writer, io.Reader) error {
+		return errors.New("failed")
+	})
+
+	wrpc.Register("panic", func(context.Context, io.Writer, io.Reader) error {
+		panic("boom")
+	})
+
+	wrpc.Register("block", func(ctx context.Context, _ io.Writer, _ io.Reader) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	wrpc.RegisterFunc("add", func(_ context.Context, req [2]int) (int, error) {
+		return req[0] + req[1], nil
+	})
+}
+
+func TestCallChain(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 2})
+	defer pool.Close()
+
+	r, w := pool.Call(wrpc.Fn("repeat", "a", 3), wrpc.Fn("upper"))
+	g.Expect(w.Close()).To(Succeed())
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("A\nA\nA\n"))
+}
+
+func TestCallPipe(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{
+		Window:   wrpcnet.Window{Messages: 4},
+		Encoding: wrpcnet.Encoding{ChunkSize: 16, Compressor: wrpcnet.Flate},
+	})
+	defer pool.Close()
+
+	r, w := pool.Call(wrpc.Fn("upper"))
+
+	data := bytes.Repeat([]byte("hello world\n"), 100)
+
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal(bytes.ToUpper(data)))
+}
+
+func TestCallError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	r, _ := pool.Call(wrpc.Fn("fail"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(MatchError("failed"))
+}
+
+func TestCallPanic(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	r, _ := pool.Call(wrpc.Fn("panic"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("panic in 'panic': boom"))
+	g.Expect(err.Error()).To(ContainSubstring("goroutine"))
+
+	// The worker recovered and serves the next call.
+	r, _ = pool.Call(wrpc.Fn("repeat", "a", 1))
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("a\n"))
+	g.Expect(pool.Stats().Spawned).To(Equal(1))
+}
+
+func TestCallNotFound(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	r, w := pool.Call(wrpc.Fn("upper"), wrpc.Fn("missing"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(MatchError(wrpc.ErrFuncNotFound))
+
+	_, err = w.Write([]byte("a"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestCallContextCancel(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r, _ := pool.CallContext(ctx, wrpc.Fn("block"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+}
+
+func TestCallContextCancelChain(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, w := pool.CallContext(ctx, wrpc.Fn("upper"), wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(HaveField("Calls", 2))
+
+	cancel()
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(MatchError(context.Canceled))
+
+	_, err = w.Write([]byte("a\n"))
+	g.Expect(err).To(MatchError(context.Canceled))
+
+	// Every func has returned and its worker is reused.
+	g.Eventually(pool.Stats).Should(And(
+		HaveField("Calls", 0),
+		HaveField("Idle", 2),
+	))
+
+	r, _ = pool.Call(wrpc.Fn("repeat", "a", 1))
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("a\n"))
+	g.Expect(pool.Stats().Spawned).To(Equal(2))
+}
+
+func TestInvoke(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	sum, err := wrpc.Invoke[[2]int, int](context.Background(), "add", [2]int{1, 2})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sum).To(Equal(3))
+}
+
+func TestWorkerClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	worker, err := wrpc.NewWorker("")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Ping(context.Background())).To(Succeed())
+
+	w, r := wrpcnet.Pipe()
+
+	called := make(chan error, 1)
+	go func() {
+		called <- worker.Call(context.Background(), w, r, wrpc.Fn("block"))
+	}()
+
+	g.Eventually(worker.Load).Should(Equal(1))
+
+	worker.Close()
+
+	g.Eventually(called).Should(Receive(MatchError(wrpc.ErrWorkerClosed)))
+	g.Expect(worker.Ping(context.Background())).To(MatchError(wrpc.ErrWorkerClosed))
+}
+
+func TestPoolWarmUp(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MinWorkers: 2})
+	defer pool.Close()
+
+	g.Expect(pool.WarmUp(context.Background())).To(Succeed())
+
+	stats := pool.Stats()
+	g.Expect(stats.Spawned).To(Equal(2))
+	g.Expect(stats.Idle).To(Equal(2))
+	g.Expect(stats.StartupTime).To(BeNumerically(">", 0))
+}
@@ -0,0 +1,289 @@
+package wrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+)
+
+// bootstrapScript runs a wasm module in a Web Worker. It waits for the init message
+// from the main thread, which carries the compiled module or null if the worker
+// must fetch and compile it. Crashes of the Go program and failures to load
+// the module are reported to the main thread through the Worker error event.
+//
+// The script exposes the module and the page URL to the Go program as wrpcBootstrap,
+// so that the workers spawned by the Go program resolve URLs against the page
+// instead of the Blob URL of the script and share the module.
+const bootstrapScript = `importScripts(%s);
+
+const go = new Go();
+go.argv = go.argv.concat(%s);
+go.env = %s;
+
+let exitCode = 0;
+const exit = go.exit;
+go.exit = (code) => {
+  exitCode = code;
+  exit(code);
+};
+
+async function compile(url) {
+  const response = await fetch(url);
+  if (!response.ok) {
+    throw new Error("error fetching " + response.url + ": " + response.status);
+  }
+  return WebAssembly.compile(await response.arrayBuffer());
+}
+
+async function run(module) {
+  module = module || (await compile(%s));
+  self.wrpcBootstrap = { baseURL: %s, source: %s, module };
+
+  const instance = await WebAssembly.instantiate(module, go.importObject);
+  await go.run(instance);
+
+  if (exitCode !== 0) {
+    throw new Error("Go program exited with code " + exitCode);
+  }
+}
+
+self.addEventListener(
+  "message",
+  (event) => {
+    run(event.data.module).catch((err) => {
+      setTimeout(() => {
+        throw err;
+      });
+    });
+  },
+  { once: true },
+);
+`
+
+// nodeBootstrapScript runs a wasm module in a Node.js worker like wasm_exec_node.js,
+// which it pretends to be so that the Go program can spawn workers of its own.
+// It waits for the same init message as bootstrapScript.
+const nodeBootstrapScript = `"use strict";
+
+const { parentPort } = require("worker_threads");
+
+process.argv[1] = %s;
+
+globalThis.require = require;
+globalThis.fs = require("fs");
+globalThis.path = require("path");
+globalThis.TextEncoder = require("util").TextEncoder;
+globalThis.TextDecoder = require("util").TextDecoder;
+
+globalThis.performance ??= require("perf_hooks").performance;
+
+globalThis.crypto ??= require("crypto");
+
+require(path.join(path.dirname(process.argv[1]), "wasm_exec"));
+
+const go = new Go();
+go.argv = process.argv.slice(2);
+go.env = Object.assign({ TMPDIR: require("os").tmpdir() }, process.env);
+go.exit = process.exit;
+
+parentPort.once("message", async ({ module }) => {
+  try {
+    module = module || (await WebAssembly.compile(fs.readFileSync(process.argv[2])));
+    globalThis.wrpcBootstrap = { source: process.argv[2], module };
+
+    const instance = await WebAssembly.instantiate(module, go.importObject);
+    process.on("exit", (code) => { // Node.js exits if no event handler is pending
+      if (code === 0 && !go.exited) {
+        // deadlock, make Go print error and stack traces
+        go._pendingEvent = { id: 0 };
+        go._resume();
+      }
+    });
+    await go.run(instance);
+  } catch (err) {
+    console.error(err);
+    process.exit(1);
+  }
+});
+`
+
+var (
+	bootstrapMu   sync.Mutex
+	bootstrapURLs = map[string]js.Value{} // Blob URLs by script
+)
+
+// bootstrapURL returns the Blob URL of the bootstrap script for config.
+// The URLs are cached for the lifetime of the program.
+func bootstrapURL(config WorkerConfig) (js.Value, error) {
+	wasmExec := config.WasmExec
+	if wasmExec == "" {
+		wasmExec = "wasm_exec.js"
+	}
+
+	env := config.Env
+	if env == nil {
+		env = map[string]string{}
+	}
+
+	// A Blob URL has no base URL for the script to resolve URLs against.
+	module := moduleSource(config)
+	literals, err := jsLiterals(resolveURL(wasmExec), config.Args, env, module, pageURL(), module)
+	if err != nil {
+		return js.Value{}, fmt.Errorf("error generating worker script: %w", err)
+	}
+
+	script := fmt.Sprintf(bootstrapScript, literals...)
+
+	bootstrapMu.Lock()
+	defer bootstrapMu.Unlock()
+
+	url, ok := bootstrapURLs[script]
+	if !ok {
+		url = jsutil.CreateURLObject(script, "text/javascript")
+		bootstrapURLs[script] = url
+	}
+
+	return url, nil
+}
+
+// jsLiterals encodes values as JavaScript literals.
+func jsLiterals(values ...any) ([]any, error) {
+	literals := make([]any, len(values))
+	for i, v := range values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		literals[i] = string(b)
+	}
+
+	return literals, nil
+}
+
+// resolveURL resolves a URL relative to the page.
+func resolveURL(url string) string {
+	base := pageURL()
+	if base == "" {
+		return url
+	}
+
+	return js.Global().Get("URL").New(url, base).Get("href").String()
+}
+
+// pageURL returns the URL of the page, or an empty string on Node.js.
+// The location of a worker started by a bootstrap script is the Blob URL
+// of the script, so the script passes on the page URL.
+func pageURL() string {
+	if bootstrap := js.Global().Get("wrpcBootstrap"); bootstrap.Type() == js.TypeObject {
+		if base := bootstrap.Get("baseURL"); base.Type() == js.TypeString {
+			return base.String()
+		}
+	}
+
+	location := js.Global().Get("location")
+	if location.Type() != js.TypeObject {
+		return ""
+	}
+
+	return location.Get("href").String()
+}
+
+// newJSWorker constructs a Web Worker or a Node.js worker_threads Worker,
+// returning the exceptions thrown by the constructor as errors.
+func newJSWorker(url string, config WorkerConfig) (worker js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("error spawning worker: %w", jsErr)
+		}
+	}()
+
+	if wt := jsutil.WorkerThreads(); !wt.IsUndefined() {
+		if url != "" {
+			return wt.Get("Worker").New(url, nodeWorkerOptions(config)), nil
+		}
+
+		script, err := nodeBootstrap()
+		if err != nil {
+			return js.Value{}, err
+		}
+
+		options := nodeWorkerOptions(config)
+		options["eval"] = true
+
+		return wt.Get("Worker").New(script, options), nil
+	}
+
+	constructor := js.Global().Get("Worker")
+	if constructor.Type() != js.TypeFunction {
+		return js.Value{}, ErrWorkersUnsupported
+	}
+
+	if url != "" {
+		return constructor.New(url), nil
+	}
+
+	bootstrap, err := bootstrapURL(config)
+	if err != nil {
+		return js.Value{}, err
+	}
+
+	return constructor.New(bootstrap), nil
+}
+
+// nodeBootstrap returns the bootstrap script of a Node.js worker.
+// The script loads wasm_exec.js from the directory of the current script,
+// such as wasm_exec_node.js.
+func nodeBootstrap() (string, error) {
+	literals, err := jsLiterals(js.Global().Get("process").Get("argv").Index(1).String())
+	if err != nil {
+		return "", fmt.Errorf("error generating worker script: %w", err)
+	}
+
+	return fmt.Sprintf(nodeBootstrapScript, literals...), nil
+}
+
+// moduleSource returns the location of the wasm module run by a worker:
+// a URL in browsers and a path on Node.js.
+func moduleSource(config WorkerConfig) string {
+	if !jsutil.WorkerThreads().IsUndefined() {
+		if config.Module == "" {
+			return js.Global().Get("process").Get("argv").Index(2).String()
+		}
+		return config.Module
+	}
+
+	if config.Module == "" {
+		return resolveURL("main.wasm")
+	}
+
+	return resolveURL(config.Module)
+}
+
+// nodeWorkerOptions returns the options of a Node.js worker. wasm_exec_node.js
+// and the bootstrap script run the module given as their first argument
+// with the remaining arguments and the environment of the worker.
+func nodeWorkerOptions(config WorkerConfig) map[string]any {
+	process := js.Global().Get("process")
+
+	argv := []any{moduleSource(config)}
+	for _, arg := range config.Args {
+		argv = append(argv, arg)
+	}
+
+	env := js.Global().Get("Object").Call("assign", map[string]any{}, process.Get("env"))
+	for k, v := range config.Env {
+		env.Set(k, v)
+	}
+
+	return map[string]any{
+		"argv": argv,
+		"env":  env,
+	}
+}
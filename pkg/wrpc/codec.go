@@ -0,0 +1,141 @@
+package wrpc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Codec encodes and decodes values of typed remote functions.
+type Codec interface {
+	// Name identifies the codec on the remote side.
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Built-in codecs.
+var (
+	// Gob encodes values using encoding/gob.
+	Gob Codec = gobCodec{}
+
+	// JSON encodes values using encoding/json.
+	JSON Codec = jsonCodec{}
+
+	// Raw encodes []byte and string values as is, encoding.BinaryMarshaler values
+	// using MarshalBinary and fixed-size values (such as numeric slices)
+	// using encoding/binary in little-endian byte order.
+	Raw Codec = rawCodec{}
+)
+
+// RegisterCodec registers a codec so that workers can decode values encoded with it.
+// The built-in codecs are registered by default.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("wrpc: unknown codec '%s'", name)
+	}
+
+	return c, nil
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		Gob.Name():  Gob,
+		JSON.Name(): JSON,
+		Raw.Name():  Raw,
+	}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "raw" }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	default:
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			return nil, fmt.Errorf("raw codec: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	case encoding.BinaryUnmarshaler:
+		return v.UnmarshalBinary(data)
+	}
+
+	// Size the target slice to fit the data.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Slice {
+		size := binary.Size(reflect.Zero(rv.Elem().Type().Elem()).Interface())
+		if size <= 0 || len(data)%size != 0 {
+			return fmt.Errorf("raw codec: cannot decode %d bytes into %T", len(data), v)
+		}
+		rv.Elem().Set(reflect.MakeSlice(rv.Elem().Type(), len(data)/size, len(data)/size))
+	}
+
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, v); err != nil {
+		return fmt.Errorf("raw codec: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package wrpc_test
+
+import (
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+type codecValue struct {
+	Name    string
+	Samples []float32
+}
+
+func expectRoundTrip[T any](g *WithT, c wrpc.Codec, v T) {
+	b, err := c.Marshal(v)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var target T
+	g.Expect(c.Unmarshal(b, &target)).To(Succeed())
+	g.Expect(target).To(Equal(v))
+}
+
+func TestCodec(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, c := range []wrpc.Codec{wrpc.Gob, wrpc.JSON} {
+		expectRoundTrip(g, c, codecValue{Name: "chunk", Samples: []float32{-1, 0, 1}})
+		expectRoundTrip(g, c, "text")
+	}
+
+	expectRoundTrip(g, wrpc.Raw, []byte{1, 2, 3})
+	expectRoundTrip(g, wrpc.Raw, "text")
+	expectRoundTrip(g, wrpc.Raw, []float32{-1, 0, 1})
+	expectRoundTrip(g, wrpc.Raw, []int16{-1, 0, 1})
+	expectRoundTrip(g, wrpc.Raw, uint64(42))
+}
+
+func TestRawCodecInvalidLength(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var target []float32
+	g.Expect(wrpc.Raw.Unmarshal([]byte{1, 2, 3}, &target)).NotTo(Succeed())
+}
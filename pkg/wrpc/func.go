@@ -0,0 +1,111 @@
+package wrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RegisterFunc registers a typed remote function that is called with a single request
+// and returns a single response.
+//
+// The request and response are encoded with the codec chosen by the caller.
+func RegisterFunc[Req, Resp any](name string, f func(context.Context, Req) (Resp, error)) {
+	Register(name, func(ctx context.Context, w io.Writer, r io.Reader) error {
+		codec, data, err := readRequest(r)
+		if err != nil {
+			return err
+		}
+
+		var req Req
+		if err := codec.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("wrpc: error decoding request: %w", err)
+		}
+
+		resp, err := f(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		b, err := codec.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("wrpc: error encoding response: %w", err)
+		}
+
+		_, err = w.Write(b)
+
+		return err
+	})
+}
+
+// Invoke calls a remote function registered with RegisterFunc on DefaultPool
+// using the Gob codec.
+//
+// An error returned by the remote function is returned from Invoke.
+func Invoke[Req, Resp any](ctx context.Context, name string, req Req) (Resp, error) {
+	return InvokeCodec[Req, Resp](ctx, Gob, name, req)
+}
+
+// InvokeCodec is like Invoke but encodes the request and response with codec.
+// The codec must be registered on the worker.
+func InvokeCodec[Req, Resp any](ctx context.Context, codec Codec, name string, req Req) (Resp, error) {
+	var resp Resp
+
+	b, err := codec.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("wrpc: error encoding request: %w", err)
+	}
+
+	r, w := CallContext(ctx, Fn(name))
+
+	// An error from the remote function is returned from the reader.
+	_, writeErr := w.Write(appendRequest(nil, codec.Name(), b))
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return resp, err
+	}
+
+	if writeErr != nil {
+		return resp, writeErr
+	}
+
+	if err := codec.Unmarshal(data, &resp); err != nil {
+		return resp, fmt.Errorf("wrpc: error decoding response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// appendRequest appends a request frame consisting of the
+// length-prefixed codec name followed by the encoded request.
+func appendRequest(dst []byte, codec string, data []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(codec)))
+	dst = append(dst, buf[:n]...)
+	dst = append(dst, codec...)
+	return append(dst, data...)
+}
+
+// readRequest reads a request frame.
+func readRequest(r io.Reader) (Codec, []byte, error) {
+	frame, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, k := binary.Uvarint(frame)
+	if k <= 0 || uint64(len(frame)-k) < n {
+		return nil, nil, errors.New("wrpc: invalid request frame")
+	}
+
+	codec, err := lookupCodec(string(frame[k : k+int(n)]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return codec, frame[k+int(n):], nil
+}
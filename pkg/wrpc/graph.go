@@ -0,0 +1,636 @@
+package wrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// SplitFunc returns the index of the parallel instance that receives a message.
+// data is the message encoded with codec, seq is the sequence number of the message
+// and n is the number of instances.
+type SplitFunc func(codec Codec, data []byte, seq uint64, n int) (int, error)
+
+// RoundRobin distributes messages evenly among the instances.
+func RoundRobin(_ Codec, _ []byte, seq uint64, n int) (int, error) {
+	return int(seq % uint64(n)), nil
+}
+
+// Keyed returns a SplitFunc that sends messages with the same key to the same instance.
+func Keyed[T any](key func(T) string) SplitFunc {
+	return func(codec Codec, data []byte, _ uint64, n int) (int, error) {
+		var v T
+		if err := codec.Unmarshal(data, &v); err != nil {
+			return 0, fmt.Errorf("wrpc: error decoding message key: %w", err)
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(key(v)))
+
+		return int(h.Sum32() % uint32(n)), nil
+	}
+}
+
+// Parallel configures a node to run on multiple workers.
+type Parallel struct {
+	// N is the number of parallel instances.
+	N int
+
+	// Split chooses the instance that receives each message.
+	// Defaults to RoundRobin.
+	Split SplitFunc
+
+	// Ordered merges the outputs of the instances in the order of the inputs.
+	// It requires the functions to send exactly one message for each message they receive.
+	Ordered bool
+}
+
+// Node is a node in a Graph. It runs a chain of typed streaming functions.
+type Node struct {
+	fns      []Func
+	parallel Parallel
+	inputs   []*Node
+}
+
+// From pipes the outputs of upstream nodes into n.
+// Messages from multiple upstream nodes are merged in arrival order.
+//
+// A node without upstream nodes receives the input of the graph.
+func (n *Node) From(upstream ...*Node) *Node {
+	n.inputs = append(n.inputs, upstream...)
+	return n
+}
+
+// Graph is a directed acyclic graph of typed streaming functions
+// registered with RegisterStream.
+//
+// Nodes without upstream nodes receive the input of the graph.
+// The output of a node is sent to every downstream node and
+// the outputs of nodes without downstream nodes are merged into the output of the graph.
+//
+// Messages between nodes are routed through the goroutine running the graph.
+type Graph struct {
+	// Pool runs the functions. Defaults to DefaultPool.
+	Pool *Pool
+
+	nodes []*Node
+}
+
+// NewGraph creates an empty graph.
+func NewGraph() *Graph {
+	return &Graph{}
+}
+
+// Node adds a node that runs the chain of functions on a single set of workers.
+func (g *Graph) Node(fns ...Func) *Node {
+	return g.Parallel(Parallel{N: 1}, fns...)
+}
+
+// Parallel adds a node that runs p.N instances of the chain of functions.
+// Each message is sent to a single instance.
+func (g *Graph) Parallel(p Parallel, fns ...Func) *Node {
+	if p.N < 1 {
+		p.N = 1
+	}
+
+	if p.Split == nil {
+		p.Split = RoundRobin
+	}
+
+	n := &Node{
+		fns:      fns,
+		parallel: p,
+	}
+
+	g.nodes = append(g.nodes, n)
+
+	return n
+}
+
+// RunGraph runs the graph. Messages sent into the graph are encoded with codec.
+//
+// The returned streams are like the ones returned by CallStream.
+func RunGraph[In, Out any](ctx context.Context, g *Graph, codec Codec) (recv *Stream[Out], send *Stream[In]) {
+	r, w := g.run(ctx)
+	return &Stream[Out]{port: r}, NewStream[In](w, codec)
+}
+
+// CallParallel is like CallStream but runs p.N instances of the chain of functions.
+func CallParallel[In, Out any](ctx context.Context, codec Codec, p Parallel, fns ...Func) (recv *Stream[Out], send *Stream[In]) {
+	g := NewGraph()
+	g.Parallel(p, fns...)
+
+	return RunGraph[In, Out](ctx, g, codec)
+}
+
+// message is an encoded message of a typed stream.
+type message struct {
+	codec string
+	data  []byte
+}
+
+// graphRun is the state of a running graph.
+type graphRun struct {
+	ctx  context.Context
+	pool *Pool
+	fail func(error)
+
+	calls       int // number of functions of every instance of every node
+	reserveOnce sync.Once
+	workers     []*Worker
+	reserveErr  error
+}
+
+// reserve returns the workers reserved for the functions from i to j of the graph.
+// The nodes run at once, so the calls of every node are reserved together.
+func (run *graphRun) reserve(i, j int) ([]*Worker, error) {
+	run.reserveOnce.Do(func() {
+		run.workers, run.reserveErr = run.pool.reserve(run.ctx, run.calls)
+	})
+
+	if run.reserveErr != nil {
+		return nil, run.reserveErr
+	}
+
+	return run.workers[i:j], nil
+}
+
+func (g *Graph) run(ctx context.Context) (*wrpcnet.MessagePort, *wrpcnet.MessagePort) {
+	inReader, localWriter := wrpcnet.Pipe()
+	localReader, outWriter := wrpcnet.Pipe()
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			localWriter.Abort(err)
+			localReader.Abort(err)
+			inReader.Abort(err)
+			outWriter.Abort(err)
+			cancel()
+		})
+	}
+
+	run := &graphRun{
+		ctx:  ctx,
+		pool: g.Pool,
+		fail: fail,
+	}
+
+	if run.pool == nil {
+		run.pool = DefaultPool
+	}
+
+	nodes, err := g.sort()
+	if err != nil {
+		fail(err)
+		return localReader, localWriter
+	}
+
+	// Create the inputs of every node and the output of the graph.
+	inputs := map[*Node]*fanIn{}
+	for _, n := range nodes {
+		inputs[n] = newFanIn()
+	}
+
+	output := newFanIn()
+
+	var sources []*fanIn
+	outputs := map[*Node][]*fanIn{}
+
+	for _, n := range nodes {
+		if len(n.inputs) == 0 {
+			sources = append(sources, inputs[n].add())
+		}
+		for _, up := range n.inputs {
+			outputs[up] = append(outputs[up], inputs[n].add())
+		}
+	}
+
+	for _, n := range nodes {
+		if len(outputs[n]) == 0 {
+			outputs[n] = append(outputs[n], output.add())
+		}
+	}
+
+	for _, in := range inputs {
+		in.start()
+	}
+	output.start()
+
+	// Route the graph input to the source nodes.
+	go func() {
+		defer closeAll(sources)
+
+		if err := run.readMessages(inReader, func(msg message) bool {
+			return run.broadcast(sources, msg)
+		}); err != nil {
+			fail(err)
+		}
+	}()
+
+	offsets := map[*Node]int{}
+	for _, n := range nodes {
+		offsets[n] = run.calls
+		run.calls += n.parallel.N * len(n.fns)
+	}
+
+	for _, n := range nodes {
+		go run.runNode(n, offsets[n], inputs[n].out, outputs[n])
+	}
+
+	// Route the outputs of the sink nodes to the graph output.
+	go func() {
+		defer cancel()
+
+		if err := run.writeMessages(outWriter, output.out); err != nil {
+			fail(err)
+			return
+		}
+
+		outWriter.Close()
+	}()
+
+	return localReader, localWriter
+}
+
+// sort returns the nodes in topological order.
+func (g *Graph) sort() ([]*Node, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[*Node]int{}
+	sorted := make([]*Node, 0, len(g.nodes))
+
+	var visit func(n *Node) error
+	visit = func(n *Node) error {
+		switch state[n] {
+		case visiting:
+			return errors.New("wrpc: graph has a cycle")
+		case visited:
+			return nil
+		}
+
+		state[n] = visiting
+		for _, up := range n.inputs {
+			if err := visit(up); err != nil {
+				return err
+			}
+		}
+		state[n] = visited
+
+		sorted = append(sorted, n)
+
+		return nil
+	}
+
+	for _, n := range g.nodes {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(sorted) != len(g.nodes) {
+		return nil, errors.New("wrpc: graph node is not part of the graph")
+	}
+
+	return sorted, nil
+}
+
+// runNode runs the instances of a node, splitting the input among them
+// and merging their outputs into the downstream inputs. The functions
+// of the node are reserved from offset.
+func (run *graphRun) runNode(n *Node, offset int, in <-chan message, downstream []*fanIn) {
+	defer closeAll(downstream)
+
+	p := n.parallel
+
+	writers := make([]*wrpcnet.MessagePort, p.N)
+	readers := make([]*wrpcnet.MessagePort, p.N)
+
+	for i := range writers {
+		start := offset + i*len(n.fns)
+		end := start + len(n.fns)
+
+		readers[i], writers[i] = run.pool.callReserved(run.ctx, func(context.Context) ([]*Worker, error) {
+			return run.reserve(start, end)
+		}, n.fns...)
+	}
+
+	var order *indexQueue
+	if p.Ordered {
+		order = newIndexQueue()
+	}
+
+	// A write fails when an instance stops reading its input. The output of
+	// the instance carries the reason, so the write error is only reported
+	// when every instance has finished without an error.
+	splitErr := make(chan error, 1)
+	defer func() {
+		if err := <-splitErr; err != nil {
+			run.fail(err)
+		}
+	}()
+
+	// Split the input among the instances.
+	go func() {
+		var writeErr error
+
+		defer func() {
+			for _, w := range writers {
+				w.Close()
+			}
+			if order != nil {
+				order.close()
+			}
+			splitErr <- writeErr
+		}()
+
+		headers := make([]string, p.N)
+
+		var seq uint64
+		for {
+			var msg message
+			select {
+			case <-run.ctx.Done():
+				return
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				msg = m
+			}
+
+			codec, err := lookupCodec(msg.codec)
+			if err != nil {
+				run.fail(err)
+				return
+			}
+
+			i, err := p.Split(codec, msg.data, seq, p.N)
+			if err != nil {
+				run.fail(err)
+				return
+			}
+
+			if order != nil {
+				order.push(i)
+			}
+
+			if err := writeMessage(writers[i], &headers[i], msg); err != nil {
+				writeErr = err
+				return
+			}
+
+			seq++
+		}
+	}()
+
+	// Unordered outputs are merged into a single channel.
+	merged := make(chan message)
+	results := make([]chan message, p.N)
+	for i := range results {
+		if order != nil {
+			results[i] = make(chan message)
+		} else {
+			results[i] = merged
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		i, r := i, r
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if order != nil {
+				defer close(results[i])
+			}
+
+			if err := run.readMessages(r, func(msg message) bool {
+				select {
+				case results[i] <- msg:
+					return true
+				case <-run.ctx.Done():
+					return false
+				}
+			}); err != nil {
+				run.fail(err)
+			}
+		}()
+	}
+
+	if order == nil {
+		go func() {
+			wg.Wait()
+			close(merged)
+		}()
+
+		for msg := range merged {
+			if !run.broadcast(downstream, msg) {
+				return
+			}
+		}
+
+		return
+	}
+
+	// Merge the outputs in the order in which the inputs were split.
+	for {
+		i, ok := order.pop()
+		if !ok {
+			break
+		}
+
+		msg, ok := <-results[i]
+		if !ok {
+			if run.ctx.Err() == nil {
+				run.fail(errors.New("wrpc: ordered node sent fewer messages than it received"))
+			}
+			return
+		}
+
+		if !run.broadcast(downstream, msg) {
+			return
+		}
+	}
+
+	for i := range results {
+		if _, ok := <-results[i]; ok {
+			run.fail(errors.New("wrpc: ordered node sent more messages than it received"))
+			return
+		}
+	}
+}
+
+// broadcast sends msg to every output. It reports whether the graph is still running.
+func (run *graphRun) broadcast(outputs []*fanIn, msg message) bool {
+	for _, out := range outputs {
+		select {
+		case out.in <- msg:
+		case <-run.ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// readMessages reads the encoded messages of a typed stream from port until EOF.
+func (run *graphRun) readMessages(port *wrpcnet.MessagePort, f func(message) bool) error {
+	header, err := port.ReadBytes()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	for {
+		data, err := port.ReadBytes()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if !f(message{codec: string(header), data: data}) {
+			return nil
+		}
+	}
+}
+
+// writeMessages writes the messages into port as a typed stream.
+func (run *graphRun) writeMessages(port *wrpcnet.MessagePort, messages <-chan message) error {
+	var header string
+
+	for {
+		select {
+		case <-run.ctx.Done():
+			return run.ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if err := writeMessage(port, &header, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeMessage writes msg into port, preceded by the stream header if it has not been written.
+func writeMessage(port *wrpcnet.MessagePort, header *string, msg message) error {
+	switch *header {
+	case "":
+		if _, err := port.Write([]byte(msg.codec)); err != nil {
+			return err
+		}
+		*header = msg.codec
+
+	case msg.codec:
+
+	default:
+		return fmt.Errorf("wrpc: cannot merge streams encoded with '%s' and '%s'", *header, msg.codec)
+	}
+
+	_, err := port.Write(msg.data)
+
+	return err
+}
+
+// fanIn merges messages from multiple senders. out is closed when every sender has closed.
+type fanIn struct {
+	in  chan message
+	out chan message
+	wg  sync.WaitGroup
+}
+
+func newFanIn() *fanIn {
+	ch := make(chan message)
+	return &fanIn{
+		in:  ch,
+		out: ch,
+	}
+}
+
+// add registers a sender. The sender must call done when finished.
+func (f *fanIn) add() *fanIn {
+	f.wg.Add(1)
+	return f
+}
+
+// start closes the output when every sender is done.
+func (f *fanIn) start() {
+	go func() {
+		f.wg.Wait()
+		close(f.in)
+	}()
+}
+
+func (f *fanIn) done() {
+	f.wg.Done()
+}
+
+func closeAll(outputs []*fanIn) {
+	for _, out := range outputs {
+		out.done()
+	}
+}
+
+// indexQueue is an unbounded FIFO queue of instance indices.
+type indexQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []int
+	closed bool
+}
+
+func newIndexQueue() *indexQueue {
+	q := &indexQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *indexQueue) push(i int) {
+	q.mu.Lock()
+	q.items = append(q.items, i)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+func (q *indexQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop returns the next index. It returns false when the queue is closed and empty.
+func (q *indexQueue) pop() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if len(q.items) == 0 {
+		return 0, false
+	}
+
+	i := q.items[0]
+	q.items = q.items[1:]
+
+	return i, true
+}
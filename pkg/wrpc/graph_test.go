@@ -0,0 +1,223 @@
+package wrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+type keyedValue struct {
+	Key      string
+	Instance int64
+}
+
+func init() {
+	wrpc.RegisterStream("inc", func(_ context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			out <- v + 1
+		}
+		return nil
+	})
+
+	wrpc.RegisterStream("double", func(_ context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			out <- v * 2
+		}
+		return nil
+	})
+
+	// slowEven delays the even values so that the instance receiving them
+	// finishes after the one receiving the odd values.
+	wrpc.RegisterStream("slowEven", func(_ context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			if v%2 == 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			out <- v
+		}
+		return nil
+	})
+
+	// instance tags the values with an ID of the instance that received them.
+	wrpc.RegisterStream("instance", func(_ context.Context, in <-chan keyedValue, out chan<- keyedValue) error {
+		id := rand.Int63()
+		for v := range in {
+			v.Instance = id
+			out <- v
+		}
+		return nil
+	})
+
+	wrpc.RegisterStream("failStream", func(_ context.Context, in <-chan int, _ chan<- int) error {
+		<-in
+		return errors.New("node failed")
+	})
+
+	wrpc.RegisterStream("hold", func(ctx context.Context, _ <-chan int, _ chan<- int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+// runGraph sends values into the graph and returns its output.
+func runGraph[In, Out any](g *wrpc.Graph, values ...In) ([]Out, error) {
+	recv, send := wrpc.RunGraph[In, Out](context.Background(), g, wrpc.Gob)
+
+	go func() {
+		defer send.Close()
+		for _, v := range values {
+			if err := send.Send(v); err != nil {
+				return
+			}
+		}
+	}()
+
+	var out []Out
+	for {
+		v, err := recv.Recv()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, v)
+	}
+}
+
+func TestGraphOrdered(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	graph := wrpc.NewGraph()
+	graph.Pool = pool
+	graph.Parallel(wrpc.Parallel{N: 2, Ordered: true}, wrpc.Fn("slowEven"))
+
+	out, err := runGraph[int, int](graph, 0, 1, 2, 3, 4, 5)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(Equal([]int{0, 1, 2, 3, 4, 5}))
+}
+
+func TestGraphKeyed(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	graph := wrpc.NewGraph()
+	graph.Pool = pool
+	graph.Parallel(wrpc.Parallel{
+		N:     3,
+		Split: wrpc.Keyed(func(v keyedValue) string { return v.Key }),
+	}, wrpc.Fn("instance"))
+
+	var values []keyedValue
+	for i := 0; i < 30; i++ {
+		values = append(values, keyedValue{Key: string(rune('a' + i%5))})
+	}
+
+	out, err := runGraph[keyedValue, keyedValue](graph, values...)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(len(values)))
+
+	instances := map[string]int64{}
+	for _, v := range out {
+		if id, ok := instances[v.Key]; ok {
+			g.Expect(v.Instance).To(Equal(id), "key %s went to multiple instances", v.Key)
+		}
+		instances[v.Key] = v.Instance
+	}
+	g.Expect(instances).To(HaveLen(5))
+}
+
+func TestGraphDiamond(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	graph := wrpc.NewGraph()
+	graph.Pool = pool
+
+	top := graph.Node(wrpc.Fn("inc"))
+	left := graph.Node(wrpc.Fn("double")).From(top)
+	right := graph.Node(wrpc.Fn("inc")).From(top)
+	graph.Node(wrpc.Fn("inc")).From(left, right)
+
+	out, err := runGraph[int, int](graph, 1, 10)
+	g.Expect(err).NotTo(HaveOccurred())
+	// 1 -> 2 -> {4, 3} -> {5, 4} and 10 -> 11 -> {22, 12} -> {23, 13}.
+	g.Expect(out).To(ConsistOf(5, 4, 23, 13))
+}
+
+func TestGraphBoundedPool(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 2})
+	defer pool.Close()
+
+	// The instances of concurrent graphs would deadlock if each graph held one of the workers.
+	results := make(chan []int, 3)
+	errs := make(chan error, cap(results))
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			graph := wrpc.NewGraph()
+			graph.Pool = pool
+			graph.Parallel(wrpc.Parallel{N: 2, Ordered: true}, wrpc.Fn("inc"))
+
+			out, err := runGraph[int, int](graph, 1, 2, 3)
+			results <- out
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < cap(results); i++ {
+		g.Eventually(errs, 5*time.Second).Should(Receive(BeNil()))
+		g.Expect(<-results).To(Equal([]int{2, 3, 4}))
+	}
+}
+
+func TestGraphInvalid(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	graph := wrpc.NewGraph()
+	a := graph.Node(wrpc.Fn("inc"))
+	b := graph.Node(wrpc.Fn("inc")).From(a)
+	a.From(b)
+
+	_, err := runGraph[int, int](graph, 1)
+	g.Expect(err).To(MatchError(ContainSubstring("cycle")))
+
+	graph = wrpc.NewGraph()
+	other := wrpc.NewGraph().Node(wrpc.Fn("inc"))
+	graph.Node(wrpc.Fn("inc")).From(other)
+
+	_, err = runGraph[int, int](graph, 1)
+	g.Expect(err).To(MatchError(ContainSubstring("not part of the graph")))
+}
+
+func TestGraphError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	graph := wrpc.NewGraph()
+	graph.Pool = pool
+	graph.Node(wrpc.Fn("failStream"))
+	graph.Node(wrpc.Fn("hold"))
+
+	_, err := runGraph[int, int](graph, 1)
+	g.Expect(err).To(MatchError(ContainSubstring("node failed")))
+
+	// The other node has been cancelled.
+	g.Eventually(pool.Stats, 2*time.Second).Should(HaveField("Calls", 0))
+}
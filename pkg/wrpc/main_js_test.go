@@ -0,0 +1,22 @@
+package wrpc_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+)
+
+// TestMain serves the registered functions when the test binary
+// is run by a Node.js worker thread spawned by the tests.
+func TestMain(m *testing.M) {
+	if jsutil.IsWorker() {
+		if err := wrpc.ListenAndServe(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	os.Exit(m.Run())
+}
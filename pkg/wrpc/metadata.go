@@ -0,0 +1,102 @@
+package wrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Func is a remote function with arguments.
+type Func struct {
+	Name string
+	Args []any
+}
+
+// Fn returns a remote function with arguments. The arguments are JSON-encoded
+// and can be decoded by the handler with Metadata.Arg.
+func Fn(name string, args ...any) Func {
+	return Func{
+		Name: name,
+		Args: args,
+	}
+}
+
+// Metadata is per-call metadata delivered to the handler alongside the call.
+type Metadata struct {
+	// CallID identifies the call.
+	CallID string `json:"callID"`
+
+	// TraceID identifies the tree of calls. Nested calls made
+	// by a handler inherit the trace ID of the handler's call.
+	TraceID string `json:"traceID"`
+
+	// Deadline is the deadline of the call. It is zero if the call has no deadline.
+	Deadline time.Time `json:"deadline"`
+
+	// Args are the JSON-encoded arguments of the function.
+	Args []json.RawMessage `json:"args"`
+}
+
+// Arg decodes the i-th argument into v.
+func (md Metadata) Arg(i int, v any) error {
+	if i >= len(md.Args) {
+		return fmt.Errorf("wrpc: argument %d not found: call has %d arguments", i, len(md.Args))
+	}
+
+	if err := json.Unmarshal(md.Args[i], v); err != nil {
+		return fmt.Errorf("wrpc: error decoding argument %d: %w", i, err)
+	}
+
+	return nil
+}
+
+// MetadataFromContext returns the metadata of the call that ctx belongs to.
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
+
+type metadataKey struct{}
+
+func withMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// newMetadata creates metadata for calling fn with ctx.
+func newMetadata(ctx context.Context, fn Func) (Metadata, error) {
+	md := Metadata{
+		CallID: newID(),
+	}
+
+	if parent, ok := MetadataFromContext(ctx); ok {
+		md.TraceID = parent.TraceID
+	} else {
+		md.TraceID = newID()
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		md.Deadline = deadline
+	}
+
+	for i, arg := range fn.Args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			return md, fmt.Errorf("wrpc: error encoding argument %d of '%s': %w", i, fn.Name, err)
+		}
+		md.Args = append(md.Args, b)
+	}
+
+	return md, nil
+}
+
+// newID returns a random identifier.
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
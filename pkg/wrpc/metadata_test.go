@@ -0,0 +1,31 @@
+package wrpc_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+func TestMetadataArg(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	md := wrpc.Metadata{
+		Args: []json.RawMessage{
+			json.RawMessage(`0.5`),
+			json.RawMessage(`"text"`),
+		},
+	}
+
+	var gain float32
+	g.Expect(md.Arg(0, &gain)).To(Succeed())
+	g.Expect(gain).To(Equal(float32(0.5)))
+
+	var s string
+	g.Expect(md.Arg(1, &s)).To(Succeed())
+	g.Expect(s).To(Equal("text"))
+
+	g.Expect(md.Arg(0, &s)).NotTo(Succeed())
+	g.Expect(md.Arg(2, &s)).NotTo(Succeed())
+}
@@ -0,0 +1,88 @@
+package wrpc
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+)
+
+// module is a wasm module compiled once and shared with the workers that run it.
+type module struct {
+	once  sync.Once
+	value js.Value // WebAssembly.Module
+	err   error
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   = map[string]*module{} // compiled modules by source
+)
+
+// compiledModule returns the WebAssembly.Module at src, compiling it on first use.
+// src is a URL in browsers and a path on Node.js. A module that failed to compile
+// is compiled again on the next use.
+//
+// In a worker started by a bootstrap script, the module the worker runs is reused.
+func compiledModule(src string) (js.Value, error) {
+	modulesMu.Lock()
+	m, ok := modules[src]
+	if !ok {
+		m = &module{}
+		modules[src] = m
+	}
+	modulesMu.Unlock()
+
+	m.once.Do(func() {
+		if bootstrap := js.Global().Get("wrpcBootstrap"); bootstrap.Type() == js.TypeObject &&
+			bootstrap.Get("source").Equal(js.ValueOf(src)) {
+			m.value = bootstrap.Get("module")
+			return
+		}
+
+		m.value, m.err = compileModule(src)
+		if m.err != nil {
+			modulesMu.Lock()
+			delete(modules, src)
+			modulesMu.Unlock()
+		}
+	})
+
+	return m.value, m.err
+}
+
+// compileModule fetches and compiles the wasm module at src.
+func compileModule(src string) (js.Value, error) {
+	var source js.Value
+
+	if !jsutil.WorkerThreads().IsUndefined() {
+		// wasm_exec_node.js exposes fs.
+		b, err := jsutil.Await(js.Global().Get("fs").Get("promises").Call("readFile", src))
+		if err != nil {
+			return js.Value{}, fmt.Errorf("error reading module %s: %w", src, err)
+		}
+		source = b
+	} else {
+		response, err := jsutil.Await(js.Global().Call("fetch", src))
+		if err != nil {
+			return js.Value{}, fmt.Errorf("error fetching module %s: %w", src, err)
+		}
+		if !response.Get("ok").Bool() {
+			return js.Value{}, fmt.Errorf("error fetching module %s: %d", src, response.Get("status").Int())
+		}
+
+		b, err := jsutil.Await(response.Call("arrayBuffer"))
+		if err != nil {
+			return js.Value{}, fmt.Errorf("error fetching module %s: %w", src, err)
+		}
+		source = b
+	}
+
+	module, err := jsutil.Await(js.Global().Get("WebAssembly").Call("compile", source))
+	if err != nil {
+		return js.Value{}, fmt.Errorf("error compiling module %s: %w", src, err)
+	}
+
+	return module, nil
+}
@@ -0,0 +1,635 @@
+package wrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// ErrPoolClosed is returned when calling on a closed pool.
+var ErrPoolClosed = errors.New("wrpc: pool closed")
+
+// ErrPoolCapacity is returned when a call chain has more functions than
+// the pool can run at once.
+var ErrPoolCapacity = errors.New("wrpc: call chain exceeds pool capacity")
+
+// DefaultPool is the pool used by Call and CallContext.
+var DefaultPool = NewPool(PoolConfig{})
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// URL is the worker script URL. The script must load and run the Go program.
+	// Empty means a bootstrap script generated from Worker.
+	URL string
+
+	// Worker configures the workers spawned without a URL.
+	Worker WorkerConfig
+
+	// MinWorkers is the number of workers spawned by WarmUp
+	// and kept alive when idle.
+	MinWorkers int
+
+	// MaxWorkers is the maximum number of workers.
+	// When all workers are at their concurrency limit,
+	// calls wait for running calls to finish.
+	// Zero means no limit.
+	//
+	// The functions of a call chain or a graph run at once, so they wait until
+	// the pool can run all of them. Chains longer than the pool can run
+	// at once fail with ErrPoolCapacity.
+	MaxWorkers int
+
+	// IdleTimeout is the duration after which idle workers above MinWorkers are terminated.
+	// Zero means idle workers are not terminated.
+	IdleTimeout time.Duration
+
+	// Window is the flow control window of the pipes between the caller and the functions.
+	// The zero value means wrpcnet.DefaultWindow.
+	Window wrpcnet.Window
+
+	// Encoding is the encoding of the data written into the pipes between
+	// the caller and the functions. It is sent to the workers with each call.
+	// The byte counters of the pipes are available from the Stats method of
+	// the Reader and WriteCloser returned by Call, which are *wrpcnet.MessagePort.
+	Encoding wrpcnet.Encoding
+
+	// HealthCheckInterval is the interval at which workers are pinged.
+	// Workers that do not answer within HealthCheckTimeout are terminated
+	// and replaced up to MinWorkers. Zero disables health checks.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the time a worker has to answer a ping.
+	// Zero means HealthCheckInterval.
+	HealthCheckTimeout time.Duration
+}
+
+// WorkerConfig configures the bootstrap script of a worker. The script is generated
+// by wrpc, so workers do not load the page's scripts.
+type WorkerConfig struct {
+	// Module is the URL of the wasm module run by the worker, relative to the page.
+	// It can be a smaller binary that only serves the worker's functions.
+	// Empty means main.wasm. On Node.js, it is a path and empty means the running module.
+	Module string
+
+	// WasmExec is the URL of Go's wasm_exec.js, relative to the page.
+	// Empty means wasm_exec.js. On Node.js, it is not used and wasm_exec.js
+	// is loaded from the directory of wasm_exec_node.js.
+	WasmExec string
+
+	// CompileInWorker makes each worker fetch and compile Module itself.
+	// By default, the spawning thread compiles Module once and sends
+	// the compiled module to each worker it spawns.
+	CompileInWorker bool
+
+	// Args are the command-line arguments of the worker's Go program, os.Args[1:].
+	Args []string
+
+	// Env are environment variables set for the worker's Go program.
+	Env map[string]string
+}
+
+// PoolStats are pool statistics.
+type PoolStats struct {
+	// Busy is the number of workers currently executing at least one call.
+	Busy int
+	// Idle is the number of workers waiting for a call.
+	Idle int
+	// Calls is the number of calls in progress.
+	Calls int
+	// Spawned is the total number of workers spawned by the pool.
+	Spawned int
+	// Unhealthy is the total number of workers terminated after failing a health check.
+	Unhealthy int
+	// StartupTime is the mean time it took the workers spawned by the pool to become ready.
+	StartupTime time.Duration
+}
+
+// Pool is a pool of Workers.
+//
+// Calls are scheduled onto workers that have not reached the concurrency limit
+// reported by their server, preferring busier workers so that a new worker is
+// only spawned when all workers are at their limit.
+type Pool struct {
+	config PoolConfig
+
+	mu          sync.Mutex
+	workers     map[*Worker]*poolWorker
+	concurrency int // last concurrency limit reported by a worker
+	spawning    int
+	spare       int // calls the spawning workers are expected to accept besides the reserved ones
+	queued      int // calls waiting for a worker being spawned
+	spawned     int
+	started     int           // workers spawned successfully
+	startupTime time.Duration // total startup time of the started workers
+	unhealthy   int
+	closed      bool
+	available   chan struct{}
+	stop        chan struct{}
+}
+
+type poolWorker struct {
+	calls     int
+	idleSince time.Time
+}
+
+// NewPool creates a worker pool. Workers are spawned on demand.
+func NewPool(config PoolConfig) *Pool {
+	p := &Pool{
+		config:      config,
+		workers:     map[*Worker]*poolWorker{},
+		concurrency: 1,
+		available:   make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+
+	if config.HealthCheckInterval > 0 {
+		go p.checkHealth()
+	}
+
+	return p
+}
+
+// WarmUp spawns workers until the pool has at least MinWorkers workers.
+func (p *Pool) WarmUp(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+
+		if p.closed {
+			p.mu.Unlock()
+			return ErrPoolClosed
+		}
+
+		n := len(p.workers) + p.spawning
+		if n >= p.config.MinWorkers || !p.canSpawn(1) {
+			p.mu.Unlock()
+			return nil
+		}
+
+		spare := p.startSpawn(0)
+		p.mu.Unlock()
+
+		if _, err := p.spawn(0, spare); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats returns the pool statistics.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := PoolStats{
+		Spawned:   p.spawned,
+		Unhealthy: p.unhealthy,
+	}
+
+	if p.started > 0 {
+		stats.StartupTime = p.startupTime / time.Duration(p.started)
+	}
+
+	for _, w := range p.workers {
+		if w.calls > 0 {
+			stats.Busy++
+		} else {
+			stats.Idle++
+		}
+		stats.Calls += w.calls
+	}
+
+	return stats
+}
+
+// Close terminates all workers. Calls that are in progress return an error.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+
+	p.closed = true
+	workers := p.workers
+	p.workers = map[*Worker]*poolWorker{}
+	p.notify()
+	close(p.stop)
+	p.mu.Unlock()
+
+	for worker := range workers {
+		worker.Close()
+	}
+}
+
+// Funcs returns the functions registered on the pool's workers, sorted by name.
+func (p *Pool) Funcs(ctx context.Context) ([]FuncInfo, error) {
+	workers, err := p.reserve(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := workers[0]
+
+	infos, err := worker.Funcs(ctx)
+	if reusable(err) {
+		p.put(worker)
+	} else {
+		p.discard(worker)
+	}
+
+	return infos, err
+}
+
+// reserve reserves n calls at once on workers that are below their concurrency limit
+// or spawns workers for the calls that do not fit. It returns a worker for each call.
+//
+// The functions of a call chain run at once. Reserving their calls one by one would
+// let concurrent chains each hold part of the workers while waiting for the rest.
+// If the pool is at MaxWorkers, reserve waits until all n calls fit.
+func (p *Pool) reserve(ctx context.Context, n int) ([]*Worker, error) {
+	for {
+		p.mu.Lock()
+
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		// The concurrency limit of the workers is known once a worker has started.
+		if limit := p.config.MaxWorkers * p.concurrency; p.started > 0 && limit > 0 && n > limit {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("%w: %d calls, at most %d", ErrPoolCapacity, n, limit)
+		}
+
+		free := p.free()
+		if free >= n {
+			workers := p.take(n)
+			p.mu.Unlock()
+
+			return workers, nil
+		}
+
+		// Each spawning worker is expected to accept as many calls as the previously
+		// spawned ones, some of which are reserved by the call that spawned it.
+		// Until a worker has started, the concurrency limit is unknown,
+		// so wait for the spawning workers instead of spawning more.
+		queue := p.queued+n <= free+p.spare || (p.started == 0 && p.spawning > 0)
+
+		// Reserve at most as many calls on a new worker as the previously spawned ones accept.
+		need := n - free
+		k := (need + p.concurrency - 1) / p.concurrency
+
+		if !queue && p.canSpawn(k) {
+			workers := p.take(free)
+
+			calls := make([]int, k)
+			spares := make([]int, k)
+			for i := range calls {
+				calls[i] = p.concurrency
+				if need < calls[i] {
+					calls[i] = need
+				}
+				need -= calls[i]
+				spares[i] = p.startSpawn(calls[i])
+			}
+			p.mu.Unlock()
+
+			spawned, err := p.spawnAll(calls, spares)
+			if err != nil {
+				for _, worker := range workers {
+					p.put(worker)
+				}
+				return nil, err
+			}
+
+			return append(workers, spawned...), nil
+		}
+
+		if !queue && p.started == 0 && p.canSpawn(1) {
+			// The calls do not fit in one worker per call.
+			// Spawn a worker to learn the concurrency limit.
+			spare := p.startSpawn(0)
+			p.mu.Unlock()
+
+			if _, err := p.spawn(0, spare); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if queue {
+			p.queued += n
+		}
+
+		available := p.available
+		p.mu.Unlock()
+
+		var err error
+		select {
+		case <-available:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		if queue {
+			p.mu.Lock()
+			p.queued -= n
+			p.mu.Unlock()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// free returns the number of calls the workers accept before reaching
+// their concurrency limit. It must be called with p.mu held.
+func (p *Pool) free() int {
+	n := 0
+	for worker, w := range p.workers {
+		if c := worker.MaxConcurrency() - w.calls; c > 0 {
+			n += c
+		}
+	}
+
+	return n
+}
+
+// take reserves n calls on the workers that are below their concurrency limit,
+// which must accept n calls. It must be called with p.mu held.
+func (p *Pool) take(n int) []*Worker {
+	workers := make([]*Worker, n)
+	for i := range workers {
+		worker := p.schedule()
+		p.workers[worker].calls++
+		workers[i] = worker
+	}
+
+	return workers
+}
+
+// schedule returns the busiest worker that is below its concurrency limit
+// or nil if there is none. It must be called with p.mu held.
+func (p *Pool) schedule() *Worker {
+	var (
+		best  *Worker
+		calls = -1
+	)
+
+	for worker, w := range p.workers {
+		if w.calls < worker.MaxConcurrency() && w.calls > calls {
+			best = worker
+			calls = w.calls
+		}
+	}
+
+	return best
+}
+
+// canSpawn reports whether n more workers fit in MaxWorkers. It must be called with p.mu held.
+func (p *Pool) canSpawn(n int) bool {
+	return p.config.MaxWorkers <= 0 || len(p.workers)+p.spawning+n <= p.config.MaxWorkers
+}
+
+// startSpawn counts a worker about to be spawned with calls reserved calls and returns
+// the number of calls it is expected to accept besides. It must be called with p.mu held.
+func (p *Pool) startSpawn(calls int) int {
+	spare := p.concurrency - calls
+
+	p.spawning++
+	p.spawned++
+	p.spare += spare
+
+	return spare
+}
+
+// spawnAll spawns a worker for each element of calls with that many calls reserved
+// and returns a worker for each reserved call. If a worker cannot be spawned,
+// the calls reserved on the other workers are released.
+func (p *Pool) spawnAll(calls, spares []int) ([]*Worker, error) {
+	spawned := make([]*Worker, len(calls))
+	errs := make([]error, len(calls))
+
+	var wg sync.WaitGroup
+	for i := range calls {
+		i := i
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spawned[i], errs[i] = p.spawn(calls[i], spares[i])
+		}()
+	}
+	wg.Wait()
+
+	var (
+		workers []*Worker
+		err     error
+	)
+
+	for i, worker := range spawned {
+		if errs[i] != nil {
+			if err == nil {
+				err = errs[i]
+			}
+			continue
+		}
+
+		for j := 0; j < calls[i]; j++ {
+			workers = append(workers, worker)
+		}
+	}
+
+	if err != nil {
+		for _, worker := range workers {
+			p.put(worker)
+		}
+		return nil, err
+	}
+
+	return workers, nil
+}
+
+// spawn spawns a worker with calls reserved calls.
+// spare is the number of calls returned by startSpawn.
+func (p *Pool) spawn(calls, spare int) (*Worker, error) {
+	worker, err := newWorker(p.config.URL, p.config.Worker)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.spawning--
+	p.spare -= spare
+
+	if err != nil {
+		p.notify()
+		return nil, err
+	}
+
+	p.started++
+	p.startupTime += worker.StartupTime()
+
+	if p.closed {
+		worker.Close()
+		return nil, ErrPoolClosed
+	}
+
+	w := &poolWorker{calls: calls}
+	p.workers[worker] = w
+	p.concurrency = worker.MaxConcurrency()
+
+	// The worker can accept more calls or callers waiting for
+	// the concurrency limit can spawn workers.
+	p.notify()
+
+	if calls == 0 {
+		p.idle(w)
+	}
+
+	return worker, nil
+}
+
+// put releases a call reserved on worker.
+func (p *Pool) put(worker *Worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		worker.Close()
+		return
+	}
+
+	w, ok := p.workers[worker]
+	if !ok {
+		// The worker was discarded.
+		return
+	}
+
+	w.calls--
+	p.notify()
+
+	if w.calls == 0 {
+		p.idle(w)
+	}
+}
+
+// idle marks a worker as idle. It must be called with p.mu held.
+func (p *Pool) idle(w *poolWorker) {
+	w.idleSince = time.Now()
+
+	if p.config.IdleTimeout > 0 {
+		time.AfterFunc(p.config.IdleTimeout, p.evictIdle)
+	}
+}
+
+// discard terminates a worker that can no longer be used.
+// Other calls in progress on the worker return an error.
+func (p *Pool) discard(worker *Worker) {
+	worker.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	if _, ok := p.workers[worker]; ok {
+		delete(p.workers, worker)
+		p.notify()
+	}
+}
+
+// checkHealth periodically pings the workers, replacing the ones that do not answer.
+func (p *Pool) checkHealth() {
+	timeout := p.config.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = p.config.HealthCheckInterval
+	}
+
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		workers := make([]*Worker, 0, len(p.workers))
+		for worker := range p.workers {
+			workers = append(workers, worker)
+		}
+		p.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+		var wg sync.WaitGroup
+		for _, worker := range workers {
+			worker := worker
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := worker.Ping(ctx); err != nil {
+					p.mu.Lock()
+					p.unhealthy++
+					p.mu.Unlock()
+
+					p.discard(worker)
+				}
+			}()
+		}
+		wg.Wait()
+		cancel()
+
+		if err := p.WarmUp(context.Background()); err != nil && !errors.Is(err, ErrPoolClosed) {
+			consoleLog("wrpc: error replacing unhealthy workers:", err.Error())
+		}
+	}
+}
+
+// evictIdle terminates workers above MinWorkers that have been idle for longer than IdleTimeout.
+func (p *Pool) evictIdle() {
+	var evicted []*Worker
+
+	p.mu.Lock()
+
+	n := len(p.workers) + p.spawning
+
+	for worker, w := range p.workers {
+		if n > p.config.MinWorkers && w.calls == 0 && time.Since(w.idleSince) >= p.config.IdleTimeout {
+			evicted = append(evicted, worker)
+			delete(p.workers, worker)
+			n--
+		}
+	}
+
+	if len(evicted) > 0 {
+		// Calls waiting for MaxWorkers can spawn workers.
+		p.notify()
+	}
+
+	p.mu.Unlock()
+
+	for _, worker := range evicted {
+		worker.Close()
+	}
+}
+
+// notify wakes up callers waiting for a worker. It must be called with p.mu held.
+func (p *Pool) notify() {
+	close(p.available)
+	p.available = make(chan struct{})
+}
@@ -0,0 +1,64 @@
+package wrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	wrpc.Register("spin", func(context.Context, io.Writer, io.Reader) error {
+		// The worker's event loop is blocked, so it stops answering pings.
+		for {
+		}
+	})
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{
+		MinWorkers:          1,
+		HealthCheckInterval: 50 * time.Millisecond,
+		HealthCheckTimeout:  50 * time.Millisecond,
+	})
+	defer pool.Close()
+
+	g.Expect(pool.WarmUp(context.Background())).To(Succeed())
+
+	r, _ := pool.Call(wrpc.Fn("spin"))
+
+	// The call fails when its worker is discarded.
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(HaveOccurred())
+
+	g.Eventually(pool.Stats, time.Second).Should(And(
+		HaveField("Unhealthy", 1),
+		HaveField("Spawned", 2),
+		HaveField("Idle", 1),
+	))
+}
+
+func TestPoolSpawnError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{URL: "./missing.js"})
+	defer pool.Close()
+
+	r, w := pool.Call(wrpc.Fn("upper"))
+
+	// The spawn error is delivered through the pipes.
+	_, err := io.ReadAll(r)
+	g.Expect(errors.Is(err, wrpc.ErrWorkerCrashed)).To(BeTrue())
+
+	_, err = w.Write([]byte("a"))
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(pool.Stats().Spawned).To(Equal(1))
+	g.Expect(pool.Stats().Idle).To(Equal(0))
+}
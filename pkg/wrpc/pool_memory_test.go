@@ -0,0 +1,126 @@
+//go:build !js
+
+package wrpc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+// setMaxConcurrency sets the concurrency limit of the in-memory workers
+// spawned during the test.
+func setMaxConcurrency(t *testing.T, n int) {
+	limit := wrpc.DefaultServer.MaxConcurrency
+	wrpc.DefaultServer.MaxConcurrency = n
+	t.Cleanup(func() { wrpc.DefaultServer.MaxConcurrency = limit })
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	g := NewGomegaWithT(t)
+	setMaxConcurrency(t, 2)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool.CallContext(ctx, wrpc.Fn("block"))
+	pool.CallContext(ctx, wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(And(
+		HaveField("Calls", 2),
+		HaveField("Busy", 1),
+	))
+	g.Expect(pool.Stats().Spawned).To(Equal(1))
+
+	// The worker is at its limit.
+	pool.CallContext(ctx, wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(And(
+		HaveField("Calls", 3),
+		HaveField("Busy", 2),
+	))
+	g.Expect(pool.Stats().Spawned).To(Equal(2))
+}
+
+func TestPoolMaxWorkers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	setMaxConcurrency(t, 2)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	pool.CallContext(ctx1, wrpc.Fn("block"))
+	pool.CallContext(ctx2, wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(HaveField("Calls", 2))
+
+	r, _ := pool.Call(wrpc.Fn("repeat", "a", 1))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(r)
+		done <- err
+	}()
+
+	// The call waits for a running call to finish.
+	g.Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+	cancel1()
+
+	g.Eventually(done).Should(Receive(BeNil()))
+	g.Expect(pool.Stats().Spawned).To(Equal(1))
+}
+
+func TestPoolWaitCancel(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	pool.Call(wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(HaveField("Calls", 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := pool.CallContext(ctx, wrpc.Fn("repeat", "a", 1))
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(MatchError(context.Canceled))
+
+	stats := pool.Stats()
+	g.Expect(stats.Calls).To(Equal(1))
+	g.Expect(stats.Spawned).To(Equal(1))
+}
+
+func TestPoolChainConcurrency(t *testing.T) {
+	g := NewGomegaWithT(t)
+	setMaxConcurrency(t, 2)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	// Both funcs of the chain run on the same worker.
+	r, w := pool.Call(wrpc.Fn("repeat", "a", 1), wrpc.Fn("upper"))
+	g.Expect(w.Close()).To(Succeed())
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("A\n"))
+	g.Expect(pool.Stats().Spawned).To(Equal(1))
+}
@@ -0,0 +1,147 @@
+package wrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+func TestPoolFuncs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+	defer pool.Close()
+
+	infos, err := pool.Funcs(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
+
+	g.Expect(sort.StringsAreSorted(names)).To(BeTrue())
+	g.Expect(names).To(ContainElements("add", "block", "repeat", "upper"))
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{
+		MinWorkers:  1,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Calls on separate workers.
+	for i := 0; i < 3; i++ {
+		pool.CallContext(ctx, wrpc.Fn("block"))
+	}
+
+	g.Eventually(pool.Stats).Should(HaveField("Calls", 3))
+	spawned := pool.Stats().Spawned
+	g.Expect(spawned).To(BeNumerically(">=", 2))
+
+	cancel()
+
+	// Idle workers are terminated down to MinWorkers.
+	g.Eventually(pool.Stats).Should(And(
+		HaveField("Idle", 1),
+		HaveField("Busy", 0),
+	))
+	g.Consistently(pool.Stats, 50*time.Millisecond).Should(HaveField("Idle", 1))
+	g.Expect(pool.Stats().Spawned).To(Equal(spawned))
+}
+
+func TestPoolClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{})
+
+	r, _ := pool.Call(wrpc.Fn("block"))
+
+	g.Eventually(pool.Stats).Should(HaveField("Calls", 1))
+
+	pool.Close()
+
+	// The call in progress fails.
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(pool.Stats()).To(And(
+		HaveField("Busy", 0),
+		HaveField("Idle", 0),
+	))
+
+	r, _ = pool.Call(wrpc.Fn("repeat", "a", 1))
+	_, err = io.ReadAll(r)
+	g.Expect(errors.Is(err, wrpc.ErrPoolClosed)).To(BeTrue())
+
+	g.Expect(pool.WarmUp(context.Background())).To(MatchError(wrpc.ErrPoolClosed))
+
+	// Closing twice is a no-op.
+	pool.Close()
+}
+
+func TestPoolConcurrentChains(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 2})
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The chains would deadlock if each held one of the workers.
+	results := make(chan error, 4)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			r, w := pool.CallContext(ctx, wrpc.Fn("repeat", "a", 1), wrpc.Fn("upper"))
+			w.Close()
+
+			b, err := io.ReadAll(r)
+			if err == nil && string(b) != "A\n" {
+				err = errors.New("unexpected output: " + string(b))
+			}
+			results <- err
+		}()
+	}
+
+	for i := 0; i < cap(results); i++ {
+		g.Expect(<-results).To(Succeed())
+	}
+
+	g.Expect(pool.Stats().Spawned).To(Equal(2))
+}
+
+func TestPoolCapacity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	// The chain does not fit in a worker that runs a single call.
+	r, w := pool.Call(wrpc.Fn("repeat", "a", 1), wrpc.Fn("upper"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(errors.Is(err, wrpc.ErrPoolCapacity)).To(BeTrue())
+
+	_, err = w.Write([]byte("a\n"))
+	g.Expect(errors.Is(err, wrpc.ErrPoolCapacity)).To(BeTrue())
+
+	// The pool can still run chains that fit.
+	r, _ = pool.Call(wrpc.Fn("repeat", "a", 1))
+
+	b, err := io.ReadAll(r)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("a\n"))
+}
@@ -1,49 +1,159 @@
 package wrpc
 
 import (
+	"context"
 	"fmt"
-	"syscall/js"
+	"io"
+	"runtime/debug"
+	"sync"
 
-	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
 	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
 )
 
-// ListenAndServe runs the server on worker.
+// DefaultServer is the server used by ListenAndServe.
+var DefaultServer = &Server{MaxConcurrency: 1}
+
+// Server serves the registered functions to the worker's parent context.
+type Server struct {
+	// MaxConcurrency is the maximum number of calls the worker executes concurrently.
+	// It is reported to the caller so that pools do not schedule more calls on the worker.
+	// Calls above the limit wait for a running call to finish.
+	// Values less than 1 mean 1.
+	MaxConcurrency int
+}
+
+// ListenAndServe runs DefaultServer on worker.
 func ListenAndServe() error {
-	port := wrpcnet.NewMessagePort(js.Global())
-	defer port.Close()
+	return DefaultServer.ListenAndServe()
+}
+
+// concurrency returns the normalized MaxConcurrency.
+func (s *Server) concurrency() int {
+	if s.MaxConcurrency < 1 {
+		return 1
+	}
+	return s.MaxConcurrency
+}
 
-	if err := port.WriteMessage(map[string]any{}, nil); err != nil {
-		return fmt.Errorf("server: error sending worker init ACK: %w", err)
+// dispatcher runs the calls of a worker within its concurrency limit.
+type dispatcher struct {
+	mu      sync.Mutex
+	calls   map[string]context.CancelFunc // in-flight calls by ID
+	slots   chan struct{}
+	session *Session // session with the parent, carried by the call contexts
+}
+
+func newDispatcher(limit int, session *Session) *dispatcher {
+	return &dispatcher{
+		calls:   map[string]context.CancelFunc{},
+		slots:   make(chan struct{}, limit),
+		session: session,
 	}
+}
 
-	for {
-		data, err := port.ReadMessage()
-		if err != nil {
-			return fmt.Errorf("server: error reading from port: %w", err)
+// dispatch runs f in a new goroutine when a slot is free and calls done
+// when the call has finished.
+func (d *dispatcher) dispatch(md Metadata, id, name string, f HandlerFunc, w, r *wrpcnet.MessagePort, done func()) {
+	ctx, cancel := newCallContext(md)
+	ctx = withSession(ctx, d.session)
+
+	d.mu.Lock()
+	d.calls[id] = cancel
+	d.mu.Unlock()
+
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			delete(d.calls, id)
+			d.mu.Unlock()
+
+			cancel()
+		}()
+
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			// Cancelled while waiting for a slot.
+			w.Abort(ctx.Err())
+			r.Abort(ctx.Err())
+			done()
+			return
 		}
 
-		call := data.Get("call")
-		switch {
-		case !call.IsUndefined():
-			name := data.Get("call").String()
-			f, ok := funcs[name]
-			if !ok {
-				fmt.Printf("server: remote func '%s' not found\n", name)
-				continue
-			}
-
-			r := wrpcnet.NewMessagePort(data.Get("r"))
-			w := wrpcnet.NewMessagePort(data.Get("w"))
-
-			if err := f(w, r); err != nil {
-				w.CloseWithError(err)
-			} else {
-				w.Close()
-			}
-
-		default:
-			jsutil.ConsoleLog("server: invalid message", data)
+		defer func() {
+			<-d.slots
+		}()
+
+		serve(ctx, name, f, w, r)
+		done()
+	}()
+}
+
+// cancel cancels an in-flight call.
+func (d *dispatcher) cancel(id string) {
+	d.mu.Lock()
+	cancel, ok := d.calls[id]
+	d.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// cancelAll cancels all in-flight calls.
+func (d *dispatcher) cancelAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, cancel := range d.calls {
+		cancel()
+	}
+}
+
+// newCallContext returns the context for a call with metadata md.
+func newCallContext(md Metadata) (context.Context, context.CancelFunc) {
+	ctx := withMetadata(context.Background(), md)
+
+	if !md.Deadline.IsZero() {
+		return context.WithDeadline(ctx, md.Deadline)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// serve runs f and closes its pipes when it has finished.
+func serve(ctx context.Context, name string, f HandlerFunc, w, r *wrpcnet.MessagePort) {
+	finished := make(chan struct{})
+
+	go func() {
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			// Unblock a handler that is not observing ctx.
+			w.Abort(ctx.Err())
+			r.Abort(ctx.Err())
 		}
+	}()
+
+	err := callHandler(ctx, name, f, w, r)
+	close(finished)
+
+	if err != nil {
+		w.CloseWithError(err)
+	} else {
+		w.Close()
 	}
+	r.Close()
+}
+
+// callHandler runs f, converting a panic into an error carrying the stack trace
+// so that the caller is unblocked instead of the worker crashing.
+func callHandler(ctx context.Context, name string, f HandlerFunc, w io.Writer, r io.Reader) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("wrpc: panic in '%s': %v\n\n%s", name, v, debug.Stack())
+		}
+	}()
+
+	return f(ctx, w, r)
 }
@@ -0,0 +1,140 @@
+package wrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// ListenAndServe runs the server on worker. On Node.js, it serves
+// through the worker_threads parentPort.
+//
+// The calls and requests served carry the session with the parent,
+// returned by SessionFromContext.
+func (s *Server) ListenAndServe() error {
+	port := wrpcnet.NewMessagePort(jsutil.ParentPort())
+	defer port.Close()
+
+	limit := s.concurrency()
+
+	if err := port.WriteMessage(map[string]any{"maxConcurrency": limit}, nil); err != nil {
+		return fmt.Errorf("server: error sending worker init ACK: %w", err)
+	}
+
+	session := newPortSession(port)
+
+	d := newDispatcher(limit, session)
+	defer d.cancelAll()
+
+	for {
+		m, err := port.ReadMessage()
+		if err != nil {
+			session.close(err)
+			return fmt.Errorf("server: error reading from port: %w", err)
+		}
+
+		data := m.JSValue()
+
+		if msg, ok := decodeSessionMessage(data); ok {
+			session.receive(msg)
+			continue
+		}
+
+		call := data.Get("call")
+		switch {
+		case !call.IsUndefined():
+			name := data.Get("call").String()
+			id := data.Get("id").String()
+			r := wrpcnet.NewMessagePort(data.Get("r"))
+			w := wrpcnet.NewMessagePort(data.Get("w"))
+			if window := data.Get("window"); !window.IsUndefined() {
+				w.SetWindow(wrpcnet.Window{
+					Messages: window.Get("messages").Int(),
+					Bytes:    window.Get("bytes").Int(),
+					Shared:   window.Get("shared").Truthy(),
+				})
+			}
+			if encoding := data.Get("encoding"); !encoding.IsUndefined() {
+				w.SetEncoding(decodeEncoding(encoding))
+			}
+
+			f, ok := funcs[name]
+			if !ok {
+				go failCall(port, map[string]any{"done": true, "id": id, "notFound": true}, w, r,
+					fmt.Errorf("%w: '%s'", ErrFuncNotFound, name))
+				continue
+			}
+
+			var md Metadata
+			if err := json.Unmarshal([]byte(data.Get("md").String()), &md); err != nil {
+				go failCall(port, map[string]any{"done": true, "id": id}, w, r,
+					fmt.Errorf("wrpc: error decoding metadata of '%s': %w", name, err))
+				continue
+			}
+
+			d.dispatch(md, id, name, f, w, r, func() {
+				reportDone(port, map[string]any{"done": true, "id": id})
+			})
+
+		case !data.Get("ping").IsUndefined():
+			go reply(port, map[string]any{"reply": true, "id": data.Get("id").String()})
+
+		case !data.Get("funcs").IsUndefined():
+			infos, err := json.Marshal(registeredFuncs())
+			if err != nil {
+				panic(err)
+			}
+
+			go reply(port, map[string]any{"reply": true, "id": data.Get("id").String(), "funcs": string(infos)})
+
+		case !data.Get("cancel").IsUndefined():
+			d.cancel(data.Get("id").String())
+
+		default:
+			jsutil.ConsoleLog("server: invalid message", data)
+		}
+	}
+}
+
+// failCall fails a call that is not dispatched, closing its pipes with err.
+func failCall(port *wrpcnet.MessagePort, done map[string]any, w, r *wrpcnet.MessagePort, err error) {
+	// Report to the caller first so that it can fail the call
+	// before the error propagates through the pipes.
+	reportDone(port, done)
+
+	w.CloseWithError(err)
+	r.CloseWithError(err)
+}
+
+// decodeEncoding decodes the Encoding of a call message. An unknown compressor
+// is logged and the data is sent uncompressed.
+func decodeEncoding(v js.Value) wrpcnet.Encoding {
+	e := wrpcnet.Encoding{ChunkSize: v.Get("chunkSize").Int()}
+
+	if name := v.Get("compressor"); !name.IsUndefined() {
+		c, err := wrpcnet.LookupCompressor(name.String())
+		if err != nil {
+			jsutil.ConsoleLog("server:", err.Error())
+		}
+		e.Compressor = c
+	}
+
+	return e
+}
+
+// reportDone notifies the caller that a call has finished.
+func reportDone(port *wrpcnet.MessagePort, msg map[string]any) {
+	if err := port.WriteMessage(msg, nil); err != nil {
+		jsutil.ConsoleLog("server: error sending call done:", err.Error())
+	}
+}
+
+// reply replies to a control request from the caller.
+func reply(port *wrpcnet.MessagePort, msg map[string]any) {
+	if err := port.WriteMessage(msg, nil); err != nil {
+		jsutil.ConsoleLog("server: error sending reply:", err.Error())
+	}
+}
@@ -0,0 +1,298 @@
+package wrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrMethodNotFound is returned when the request method is not registered on the other side of a session.
+var ErrMethodNotFound = errors.New("wrpc: session method not found")
+
+// RequestHandler handles a request received on a Session.
+//
+// The context is cancelled when the requester cancels the request
+// and carries the session, so that the handler can make requests of its own.
+type RequestHandler func(ctx context.Context, req []byte) ([]byte, error)
+
+// RegisterRequest registers a handler for the requests with method received
+// on any session of the program: from the workers on the main thread
+// and from the parent in a worker.
+func RegisterRequest(method string, h RequestHandler) {
+	requestHandlers[method] = h
+}
+
+var requestHandlers = map[string]RequestHandler{}
+
+// RegisterRequestFunc registers a typed request handler.
+//
+// The request and response are encoded with the codec chosen by the requester.
+func RegisterRequestFunc[Req, Resp any](method string, f func(context.Context, Req) (Resp, error)) {
+	RegisterRequest(method, func(ctx context.Context, frame []byte) ([]byte, error) {
+		codec, data, err := readRequest(bytes.NewReader(frame))
+		if err != nil {
+			return nil, err
+		}
+
+		var req Req
+		if err := codec.Unmarshal(data, &req); err != nil {
+			return nil, fmt.Errorf("wrpc: error decoding request: %w", err)
+		}
+
+		resp, err := f(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := codec.Marshal(resp)
+		if err != nil {
+			return nil, fmt.Errorf("wrpc: error encoding response: %w", err)
+		}
+
+		return b, nil
+	})
+}
+
+// Request sends a request to a handler registered with RegisterRequestFunc
+// on the other side of s using the Gob codec.
+//
+// An error returned by the handler is returned from Request.
+func Request[Req, Resp any](ctx context.Context, s *Session, method string, req Req) (Resp, error) {
+	return RequestCodec[Req, Resp](ctx, s, Gob, method, req)
+}
+
+// RequestCodec is like Request but encodes the request and response with codec.
+// The codec must be registered on the other side.
+func RequestCodec[Req, Resp any](ctx context.Context, s *Session, codec Codec, method string, req Req) (Resp, error) {
+	var resp Resp
+
+	b, err := codec.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("wrpc: error encoding request: %w", err)
+	}
+
+	data, err := s.Request(ctx, method, appendRequest(nil, codec.Name(), b))
+	if err != nil {
+		return resp, err
+	}
+
+	if err := codec.Unmarshal(data, &resp); err != nil {
+		return resp, fmt.Errorf("wrpc: error decoding response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Session is a long-lived duplex session between a worker and its parent.
+// Either side can send requests to the other, such as a worker asking
+// the main thread to fetch a URL or read DOM state. Requests and responses
+// are multiplexed by their IDs over the worker's control port.
+//
+// The session of a worker is returned by Worker.Session on the parent
+// and by SessionFromContext in the calls and requests the worker serves.
+// It ends when the worker is closed.
+type Session struct {
+	post func(sessionMessage) error
+
+	mu       sync.Mutex
+	pending  map[string]chan sessionMessage // sent requests by ID
+	handling map[string]context.CancelFunc  // received requests by ID
+	done     chan struct{}
+	err      error
+}
+
+// sessionMessage is a message of the session protocol.
+type sessionMessage struct {
+	kind     string // request, response or cancelRequest
+	id       string
+	method   string
+	data     []byte
+	err      string
+	notFound bool
+}
+
+const (
+	sessionRequest  = "request"
+	sessionResponse = "response"
+	sessionCancel   = "cancelRequest"
+)
+
+// newSession creates a session that sends its messages with post.
+func newSession(post func(sessionMessage) error) *Session {
+	return &Session{
+		post:     post,
+		pending:  map[string]chan sessionMessage{},
+		handling: map[string]context.CancelFunc{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Request sends a request to the handler registered for method on the other side
+// and waits for the response.
+//
+// If ctx is cancelled before that, the handler's context is cancelled
+// and Request returns ctx.Err().
+func (s *Session) Request(ctx context.Context, method string, req []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	id := newID()
+	result := make(chan sessionMessage, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("wrpc: error sending request '%s': %w", method, s.err)
+	}
+	s.pending[id] = result
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.post(sessionMessage{kind: sessionRequest, id: id, method: method, data: req}); err != nil {
+		return nil, fmt.Errorf("wrpc: error sending request '%s': %w", method, err)
+	}
+
+	select {
+	case resp := <-result:
+		switch {
+		case resp.notFound:
+			return nil, fmt.Errorf("%w: '%s'", ErrMethodNotFound, method)
+		case resp.err != "":
+			return nil, errors.New(resp.err)
+		default:
+			return resp.data, nil
+		}
+	case <-s.done:
+		return nil, fmt.Errorf("wrpc: error waiting for response to '%s': %w", method, s.err)
+	case <-ctx.Done():
+		if err := s.post(sessionMessage{kind: sessionCancel, id: id}); err != nil {
+			consoleLog("wrpc: error cancelling request:", err.Error())
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// receive handles a message from the other side. It does not block.
+func (s *Session) receive(msg sessionMessage) {
+	switch msg.kind {
+	case sessionRequest:
+		s.serve(msg)
+
+	case sessionResponse:
+		s.mu.Lock()
+		result, ok := s.pending[msg.id]
+		s.mu.Unlock()
+
+		if ok {
+			result <- msg
+		}
+
+	case sessionCancel:
+		s.mu.Lock()
+		cancel, ok := s.handling[msg.id]
+		s.mu.Unlock()
+
+		if ok {
+			cancel()
+		}
+	}
+}
+
+// serve runs the handler of a request in a new goroutine and sends its response.
+func (s *Session) serve(msg sessionMessage) {
+	h, ok := requestHandlers[msg.method]
+	if !ok {
+		go s.respond(sessionMessage{kind: sessionResponse, id: msg.id, notFound: true})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withSession(context.Background(), s))
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		cancel()
+		return
+	}
+	s.handling[msg.id] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.handling, msg.id)
+			s.mu.Unlock()
+
+			cancel()
+		}()
+
+		data, err := callRequestHandler(ctx, msg.method, h, msg.data)
+
+		resp := sessionMessage{kind: sessionResponse, id: msg.id, data: data}
+		if err != nil {
+			resp.data = nil
+			resp.err = err.Error()
+		}
+
+		s.respond(resp)
+	}()
+}
+
+// respond sends a response to the other side.
+func (s *Session) respond(resp sessionMessage) {
+	if err := s.post(resp); err != nil {
+		consoleLog("wrpc: error sending response:", err.Error())
+	}
+}
+
+// close ends the session. Pending requests return err
+// and the contexts of the handlers are cancelled.
+func (s *Session) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	s.err = err
+	close(s.done)
+
+	for _, cancel := range s.handling {
+		cancel()
+	}
+}
+
+// callRequestHandler runs h, converting a panic into an error carrying the stack trace.
+func callRequestHandler(ctx context.Context, method string, h RequestHandler, req []byte) (resp []byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("wrpc: panic in request '%s': %v\n\n%s", method, v, debug.Stack())
+		}
+	}()
+
+	return h(ctx, req)
+}
+
+// SessionFromContext returns the session with the parent of the worker
+// that serves the call or request that ctx belongs to.
+// On the parent, it returns the session of a request received from a worker.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok
+}
+
+type sessionKey struct{}
+
+func withSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
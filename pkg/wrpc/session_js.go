@@ -0,0 +1,73 @@
+package wrpc
+
+import (
+	"syscall/js"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/array"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// newPortSession creates a session over a worker's control port.
+func newPortSession(port *wrpcnet.MessagePort) *Session {
+	return newSession(func(msg sessionMessage) error {
+		data, transferables := encodeSessionMessage(msg)
+		return port.WriteMessage(data, transferables)
+	})
+}
+
+// encodeSessionMessage encodes a session message for the control port.
+// The data is transferred as an ArrayBuffer.
+func encodeSessionMessage(msg sessionMessage) (map[string]any, []any) {
+	data := map[string]any{msg.kind: true, "id": msg.id}
+	if msg.kind == sessionRequest {
+		data[sessionRequest] = msg.method
+	}
+	if msg.err != "" {
+		data["err"] = msg.err
+	}
+	if msg.notFound {
+		data["notFound"] = true
+	}
+
+	var transferables []any
+	if msg.data != nil {
+		ab := array.NewFromSlice(msg.data).ArrayBuffer()
+		data["data"] = ab
+		transferables = []any{ab}
+	}
+
+	return data, transferables
+}
+
+// decodeSessionMessage decodes a session message from the control port.
+// It returns false if data is not a session message.
+func decodeSessionMessage(data js.Value) (sessionMessage, bool) {
+	var msg sessionMessage
+
+	switch {
+	case !data.Get(sessionRequest).IsUndefined():
+		msg.kind = sessionRequest
+		msg.method = data.Get(sessionRequest).String()
+	case !data.Get(sessionResponse).IsUndefined():
+		msg.kind = sessionResponse
+	case !data.Get(sessionCancel).IsUndefined():
+		msg.kind = sessionCancel
+	default:
+		return msg, false
+	}
+
+	msg.id = data.Get("id").String()
+	msg.notFound = data.Get("notFound").Truthy()
+
+	if err := data.Get("err"); err.Type() == js.TypeString {
+		msg.err = err.String()
+	}
+
+	if ab := data.Get("data"); ab.Type() == js.TypeObject {
+		arr := array.NewUint8Array(ab)
+		msg.data = make([]byte, arr.Len())
+		arr.CopyBytesToGo(msg.data)
+	}
+
+	return msg, true
+}
@@ -0,0 +1,99 @@
+package wrpc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	// Served by the parent.
+	wrpc.RegisterRequestFunc("hostGreet", func(_ context.Context, name string) (string, error) {
+		return "hello " + name, nil
+	})
+
+	// Served by the worker.
+	wrpc.RegisterRequestFunc("workerUpper", func(_ context.Context, s string) (string, error) {
+		return strings.ToUpper(s), nil
+	})
+
+	wrpc.RegisterRequest("workerBlock", func(ctx context.Context, _ []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	wrpc.RegisterRequest("workerFail", func(context.Context, []byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	})
+
+	wrpc.RegisterFunc("greetViaHost", func(ctx context.Context, name string) (string, error) {
+		session, ok := wrpc.SessionFromContext(ctx)
+		if !ok {
+			return "", errors.New("no session")
+		}
+		return wrpc.Request[string, string](ctx, session, "hostGreet", name)
+	})
+}
+
+func TestSessionWorkerToParent(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	greeting, err := wrpc.Invoke[string, string](context.Background(), "greetViaHost", "worker")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(greeting).To(Equal("hello worker"))
+}
+
+func TestSessionParentToWorker(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	worker, err := wrpc.NewWorker("")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer worker.Close()
+
+	s, err := wrpc.Request[string, string](context.Background(), worker.Session(), "workerUpper", "push")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s).To(Equal("PUSH"))
+
+	_, err = worker.Session().Request(context.Background(), "workerFail", nil)
+	g.Expect(err).To(MatchError("failed"))
+
+	_, err = worker.Session().Request(context.Background(), "missing", nil)
+	g.Expect(err).To(MatchError(wrpc.ErrMethodNotFound))
+}
+
+func TestSessionCancel(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	worker, err := wrpc.NewWorker("")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer worker.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = worker.Session().Request(ctx, "workerBlock", nil)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+}
+
+func TestSessionClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	worker, err := wrpc.NewWorker("")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := worker.Session().Request(context.Background(), "workerBlock", nil)
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	worker.Close()
+
+	g.Eventually(result).Should(Receive(MatchError(wrpc.ErrWorkerClosed)))
+}
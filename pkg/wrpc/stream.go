@@ -0,0 +1,217 @@
+package wrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// Stream sends or receives typed messages over a MessagePort.
+// Each message is sent as a single MessagePort message.
+//
+// The first message on a stream is a header that names the codec used
+// by the sender, so the receiver can decode messages sent with any registered codec.
+type Stream[T any] struct {
+	port   *wrpcnet.MessagePort
+	enc    Codec
+	dec    Codec
+	header bool
+}
+
+// NewStream creates a typed stream over port. Messages are sent encoded with codec.
+func NewStream[T any](port *wrpcnet.MessagePort, codec Codec) *Stream[T] {
+	return &Stream[T]{
+		port: port,
+		enc:  codec,
+	}
+}
+
+// Send a message. It blocks until the remote side receives the message.
+func (s *Stream[T]) Send(v T) error {
+	if !s.header {
+		if _, err := s.port.Write([]byte(s.enc.Name())); err != nil {
+			return err
+		}
+		s.header = true
+	}
+
+	b, err := s.enc.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("wrpc: error encoding message: %w", err)
+	}
+
+	_, err = s.port.Write(b)
+
+	return err
+}
+
+// Recv receives a message. It returns io.EOF when the remote side has closed the stream.
+func (s *Stream[T]) Recv() (T, error) {
+	var v T
+
+	if s.dec == nil {
+		name, err := s.port.ReadBytes()
+		if err != nil {
+			return v, err
+		}
+
+		if s.dec, err = lookupCodec(string(name)); err != nil {
+			return v, err
+		}
+	}
+
+	b, err := s.port.ReadBytes()
+	if err != nil {
+		return v, err
+	}
+
+	if err := s.dec.Unmarshal(b, &v); err != nil {
+		return v, fmt.Errorf("wrpc: error decoding message: %w", err)
+	}
+
+	return v, nil
+}
+
+// SendPort transfers port to the remote side, which receives it with RecvPort.
+// Messages and ports are received in the order they were sent.
+func (s *Stream[T]) SendPort(port *wrpcnet.MessagePort) error {
+	return s.port.SendPort(port)
+}
+
+// RecvPort receives a port sent with SendPort.
+func (s *Stream[T]) RecvPort() (*wrpcnet.MessagePort, error) {
+	return s.port.ReceivePort()
+}
+
+// Close the stream. The remote side receives io.EOF.
+func (s *Stream[T]) Close() error {
+	return s.port.Close()
+}
+
+// CloseWithError closes the stream. The remote side receives err.
+func (s *Stream[T]) CloseWithError(err error) {
+	s.port.CloseWithError(err)
+}
+
+// StreamFunc is a typed streaming remote function. It receives messages from in
+// and sends messages to out. in is closed when the remote side has finished sending.
+type StreamFunc[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+// RegisterStream registers a typed streaming remote function.
+// Messages sent by f are encoded with the Gob codec.
+func RegisterStream[In, Out any](name string, f StreamFunc[In, Out]) {
+	RegisterStreamCodec(name, Gob, f)
+}
+
+// RegisterStreamCodec is like RegisterStream but messages sent by f are encoded with codec.
+func RegisterStreamCodec[In, Out any](name string, codec Codec, f StreamFunc[In, Out]) {
+	Register(name, func(ctx context.Context, w io.Writer, r io.Reader) error {
+		return serveStream(ctx,
+			&Stream[In]{port: r.(*wrpcnet.MessagePort)},
+			NewStream[Out](w.(*wrpcnet.MessagePort), codec),
+			f,
+		)
+	})
+}
+
+// CallStream is like CallContext but returns typed streams.
+//
+// The returned send stream is piped to the first func's input
+// and the returned receive stream is piped from the last func's output.
+// Messages sent into the chain are encoded with codec.
+func CallStream[In, Out any](ctx context.Context, codec Codec, fns ...Func) (recv *Stream[Out], send *Stream[In]) {
+	r, w := DefaultPool.call(ctx, fns...)
+	return &Stream[Out]{port: r}, NewStream[In](w, codec)
+}
+
+// SendPort transfers port through w, which must be a Writer passed to a HandlerFunc
+// or returned by Call. The remote side receives the port with ReceivePort.
+//
+// This lets a function hand a pipe to a peer chosen at runtime, for example
+// so that another function writes its output directly into it.
+func SendPort(w io.Writer, port *wrpcnet.MessagePort) error {
+	wp, ok := w.(*wrpcnet.MessagePort)
+	if !ok {
+		return fmt.Errorf("wrpc: cannot send a port through %T", w)
+	}
+
+	return wp.SendPort(port)
+}
+
+// ReceivePort receives a port sent with SendPort through r, which must be
+// a Reader passed to a HandlerFunc or returned by Call.
+func ReceivePort(r io.Reader) (*wrpcnet.MessagePort, error) {
+	rp, ok := r.(*wrpcnet.MessagePort)
+	if !ok {
+		return nil, fmt.Errorf("wrpc: cannot receive a port from %T", r)
+	}
+
+	return rp.ReceivePort()
+}
+
+func serveStream[In, Out any](ctx context.Context, recv *Stream[In], send *Stream[Out], f StreamFunc[In, Out]) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in := make(chan In)
+	out := make(chan Out)
+	recvErr := make(chan error, 1)
+	sendErr := make(chan error, 1)
+
+	go func() {
+		defer close(in)
+		for {
+			v, err := recv.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					recvErr <- err
+					cancel()
+				}
+				return
+			}
+
+			select {
+			case in <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for v := range out {
+			if err := send.Send(v); err != nil {
+				sendErr <- err
+				cancel()
+				// Unblock f.
+				for range out {
+				}
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+
+	err := func() error {
+		// Stop the sender even if f panics.
+		defer close(out)
+		return f(ctx, in, out)
+	}()
+
+	// A failed receive cancels ctx, so its error takes precedence
+	// over the ctx.Err() that f returns.
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return <-sendErr
+}
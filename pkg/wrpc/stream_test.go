@@ -0,0 +1,105 @@
+package wrpc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	wrpc.RegisterStream("drain", func(ctx context.Context, in <-chan string, _ chan<- string) error {
+		for {
+			select {
+			case _, ok := <-in:
+				if !ok {
+					return nil
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+func TestStream(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	send := wrpc.NewStream[codecValue](p1, wrpc.JSON)
+	recv := wrpc.NewStream[codecValue](p2, wrpc.Gob)
+
+	values := []codecValue{
+		{Name: "a", Samples: []float32{1}},
+		{Name: "b", Samples: []float32{1, 2}},
+		{Name: "c"},
+	}
+
+	go func() {
+		defer send.Close()
+		for _, v := range values {
+			if err := send.Send(v); err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	for _, v := range values {
+		received, err := recv.Recv()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(received).To(Equal(v))
+	}
+
+	_, err := recv.Recv()
+	g.Expect(err).To(MatchError(io.EOF))
+}
+
+func TestStreamPort(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+	send := wrpc.NewStream[codecValue](p1, wrpc.JSON)
+	recv := wrpc.NewStream[codecValue](p2, wrpc.JSON)
+
+	local, remote := wrpcnet.Pipe()
+
+	g.Expect(send.Send(codecValue{Name: "a"})).To(Succeed())
+	g.Expect(send.SendPort(remote)).To(Succeed())
+
+	_, err := remote.Write([]byte{1})
+	g.Expect(err).To(MatchError(wrpcnet.ErrTransferred))
+
+	v, err := recv.Recv()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(v).To(Equal(codecValue{Name: "a"}))
+
+	port, err := recv.RecvPort()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	go port.Write([]byte("hello"))
+
+	b, err := local.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("hello"))
+}
+
+func TestStreamReceiveError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := wrpc.NewPool(wrpc.PoolConfig{MaxWorkers: 1})
+	defer pool.Close()
+
+	r, w := pool.Call(wrpc.Fn("drain"))
+
+	// The codec header names a codec that does not exist. The write may fail
+	// with the error of the func, which closes the pipe.
+	_, _ = w.Write([]byte("nope"))
+
+	_, err := io.ReadAll(r)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("unknown codec 'nope'"))
+}
@@ -0,0 +1,439 @@
+package wrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// ErrWorkersUnsupported is returned by NewWorker when the JS environment
+// has neither Web Workers nor Node.js worker_threads.
+var ErrWorkersUnsupported = errors.New("wrpc: workers are not supported")
+
+// Worker is a Web Worker or Node.js worker_threads Worker wrapper. It is safe
+// to make concurrent calls on a Worker, up to the concurrency limit reported by its server.
+type Worker struct {
+	worker         js.Value
+	port           *wrpcnet.MessagePort
+	session        *Session
+	listeners      []listener
+	maxConcurrency int
+	startupTime    time.Duration
+
+	mu       sync.Mutex
+	calls    map[string]chan js.Value // in-flight calls by ID
+	requests map[string]chan js.Value // in-flight control requests by ID
+	stopped  chan struct{}
+	err      error
+}
+
+// listener is an event listener on the worker.
+type listener struct {
+	event string
+	f     js.Func
+}
+
+// Close terminates the worker. A call in progress returns ErrWorkerClosed.
+func (wk *Worker) Close() {
+	wk.worker.Call("terminate")
+	wk.port.Abort(ErrWorkerClosed)
+
+	// A Node.js worker emits exit after it has been terminated.
+	for _, l := range wk.listeners {
+		if wk.worker.Get("removeEventListener").Type() == js.TypeFunction {
+			wk.worker.Call("removeEventListener", l.event, l.f)
+		} else {
+			wk.worker.Call("off", l.event, l.f)
+		}
+		l.f.Release()
+	}
+	wk.listeners = nil
+}
+
+// on adds an event listener that is removed when the worker is closed.
+func (wk *Worker) on(event string, f func(js.Value)) {
+	fn := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		f(args[0])
+		return nil
+	})
+
+	if wk.worker.Get("addEventListener").Type() == js.TypeFunction {
+		wk.worker.Call("addEventListener", event, fn)
+	} else {
+		wk.worker.Call("on", event, fn)
+	}
+
+	wk.listeners = append(wk.listeners, listener{event: event, f: fn})
+}
+
+// MaxConcurrency returns the maximum number of concurrent calls the worker accepts.
+func (wk *Worker) MaxConcurrency() int {
+	return wk.maxConcurrency
+}
+
+// Load returns the number of calls in progress on the worker.
+func (wk *Worker) Load() int {
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	return len(wk.calls)
+}
+
+// Session returns the session with the worker.
+func (wk *Worker) Session() *Session {
+	return wk.session
+}
+
+// StartupTime returns the time it took the worker to become ready after it was spawned.
+func (wk *Worker) StartupTime() time.Duration {
+	return wk.startupTime
+}
+
+// Call synchronously executes a remote call on the worker.
+// It returns when the remote function has finished.
+//
+// If ctx is cancelled before that, a cancel message is sent to the worker
+// and Call waits for the remote function to return before returning ctx.Err().
+//
+// The call carries Metadata with the arguments of fn and the deadline of ctx.
+//
+// If the call cannot be dispatched, w and r are closed with the error.
+func (wk *Worker) Call(ctx context.Context, w, r *wrpcnet.MessagePort, fn Func) error {
+	if err := ctx.Err(); err != nil {
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	md, err := newMetadata(ctx, fn)
+	if err != nil {
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	mdJSON, err := json.Marshal(md)
+	if err != nil {
+		panic(err)
+	}
+
+	name := fn.Name
+	id := md.CallID
+	window := w.Window()
+	messages := map[string]any{
+		"call": name,
+		"id":   id,
+		"md":   string(mdJSON),
+		"w":    w.Value,
+		"r":    r.Value,
+		// The flow control window of w applies to the worker's end of it.
+		"window": map[string]any{
+			"messages": window.Messages,
+			"bytes":    window.Bytes,
+			"shared":   window.Shared,
+		},
+		// So does the encoding.
+		"encoding": encodingMessage(w.Encoding()),
+	}
+
+	var transferables []any
+
+	if r == w {
+		transferables = []any{w.Value}
+	} else {
+		transferables = []any{w.Value, r.Value}
+	}
+
+	result := wk.track(id)
+	defer wk.untrack(id)
+
+	if err := wk.port.WriteMessage(messages, transferables); err != nil {
+		err = fmt.Errorf("error dispatching call '%s': %w", name, err)
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- wk.wait(name, result)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if err := wk.port.WriteMessage(map[string]any{"cancel": true, "id": id}, nil); err != nil {
+			return fmt.Errorf("error cancelling call '%s': %w", name, err)
+		}
+		if err := <-done; err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+// encodingMessage encodes an Encoding for the call message.
+func encodingMessage(e wrpcnet.Encoding) map[string]any {
+	msg := map[string]any{"chunkSize": e.ChunkSize}
+	if e.Compressor != nil {
+		msg["compressor"] = e.Compressor.Name()
+	}
+
+	return msg
+}
+
+// track registers an in-flight call. The returned channel receives
+// the message reporting that the call has finished.
+func (wk *Worker) track(id string) chan js.Value {
+	result := make(chan js.Value, 1)
+
+	wk.mu.Lock()
+	wk.calls[id] = result
+	wk.mu.Unlock()
+
+	return result
+}
+
+func (wk *Worker) untrack(id string) {
+	wk.mu.Lock()
+	delete(wk.calls, id)
+	wk.mu.Unlock()
+}
+
+// wait waits for the worker to report that the call has finished.
+func (wk *Worker) wait(name string, result <-chan js.Value) error {
+	select {
+	case data := <-result:
+		if !data.Get("notFound").IsUndefined() {
+			return fmt.Errorf("%w: '%s'", ErrFuncNotFound, name)
+		}
+		return nil
+	case <-wk.stopped:
+		return fmt.Errorf("error waiting for call '%s': %w", name, wk.err)
+	}
+}
+
+// Ping checks that the worker is responsive. The worker's server answers pings
+// independently of the calls it is executing, so a worker that does not answer
+// before ctx is done is hung or has crashed.
+func (wk *Worker) Ping(ctx context.Context) error {
+	_, err := wk.request(ctx, "ping")
+	return err
+}
+
+// Funcs returns the functions registered on the worker, sorted by name.
+func (wk *Worker) Funcs(ctx context.Context) ([]FuncInfo, error) {
+	data, err := wk.request(ctx, "funcs")
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []FuncInfo
+	if err := json.Unmarshal([]byte(data.Get("funcs").String()), &infos); err != nil {
+		return nil, fmt.Errorf("error decoding funcs: %w", err)
+	}
+
+	return infos, nil
+}
+
+// request sends a control request to the worker's server and waits for the reply.
+func (wk *Worker) request(ctx context.Context, kind string) (js.Value, error) {
+	id := newID()
+	result := make(chan js.Value, 1)
+
+	wk.mu.Lock()
+	wk.requests[id] = result
+	wk.mu.Unlock()
+
+	defer func() {
+		wk.mu.Lock()
+		delete(wk.requests, id)
+		wk.mu.Unlock()
+	}()
+
+	// The write blocks until the server reads the request.
+	if err := wk.port.WriteMessageContext(ctx, map[string]any{kind: true, "id": id}, nil); err != nil {
+		return js.Value{}, fmt.Errorf("error sending %s request: %w", kind, err)
+	}
+
+	select {
+	case data := <-result:
+		return data, nil
+	case <-wk.stopped:
+		return js.Value{}, fmt.Errorf("error waiting for %s reply: %w", kind, wk.err)
+	case <-ctx.Done():
+		return js.Value{}, ctx.Err()
+	}
+}
+
+// receive dispatches the messages from the worker to the calls and requests waiting for them
+// and to the session.
+func (wk *Worker) receive() {
+	for {
+		m, err := wk.port.ReadMessage()
+		if err != nil {
+			wk.err = err
+			close(wk.stopped)
+			wk.session.close(err)
+			return
+		}
+
+		data := m.JSValue()
+
+		if msg, ok := decodeSessionMessage(data); ok {
+			wk.session.receive(msg)
+			continue
+		}
+
+		var pending map[string]chan js.Value
+		switch {
+		case !data.Get("done").IsUndefined():
+			pending = wk.calls
+		case !data.Get("reply").IsUndefined():
+			pending = wk.requests
+		default:
+			jsutil.ConsoleLog("worker: invalid message", data)
+			continue
+		}
+
+		wk.mu.Lock()
+		result, ok := pending[data.Get("id").String()]
+		wk.mu.Unlock()
+
+		if ok {
+			result <- data
+		}
+	}
+}
+
+// NewWorker spawns a worker running the script at url. An empty url
+// means a bootstrap script generated from the zero WorkerConfig.
+//
+// On Node.js, the worker is a worker_threads Worker that runs url,
+// or the current script if url is empty, so that it runs the same wasm module.
+func NewWorker(url string) (*Worker, error) {
+	return newWorker(url, WorkerConfig{})
+}
+
+// SpawnWorker spawns a worker running a bootstrap script generated from config.
+func SpawnWorker(config WorkerConfig) (*Worker, error) {
+	return newWorker("", config)
+}
+
+func newWorker(url string, config WorkerConfig) (*Worker, error) {
+	start := time.Now()
+
+	worker, err := newJSWorker(url, config)
+	if err != nil {
+		return nil, err
+	}
+
+	port := wrpcnet.NewMessagePort(worker)
+
+	newWorker := &Worker{
+		worker:   worker,
+		port:     port,
+		session:  newPortSession(port),
+		calls:    map[string]chan js.Value{},
+		requests: map[string]chan js.Value{},
+		stopped:  make(chan struct{}),
+	}
+
+	// Fail the pending calls when the worker crashes.
+	newWorker.on("error", func(event js.Value) {
+		if event.Get("preventDefault").Type() == js.TypeFunction {
+			event.Call("preventDefault")
+		}
+		newWorker.port.Abort(crashError(event))
+	})
+
+	if jsutil.IsNode() {
+		// A Node.js worker exits after an uncaught error or when its Go program exits.
+		newWorker.on("exit", func(code js.Value) {
+			newWorker.port.Abort(fmt.Errorf("%w: worker exited with code %d", ErrWorkerCrashed, code.Int()))
+		})
+	}
+
+	// A bootstrap script waits for the module before starting the Go program.
+	if url == "" {
+		if err := newWorker.init(config); err != nil {
+			newWorker.Close()
+			return nil, err
+		}
+	}
+
+	// Wait for the worker to be ready.
+	data, err := newWorker.port.ReadMessage()
+	if err != nil {
+		newWorker.Close()
+		return nil, fmt.Errorf("error waiting for worker to become ready: %w", err)
+	}
+
+	newWorker.maxConcurrency = 1
+	if n, err := data.Get("maxConcurrency").Int(); err == nil && n > 1 {
+		newWorker.maxConcurrency = n
+	}
+
+	newWorker.startupTime = time.Since(start)
+
+	go newWorker.receive()
+
+	return newWorker, nil
+}
+
+// init sends the init message to the bootstrap script of the worker. The message
+// carries the module compiled by the calling thread unless config.CompileInWorker is set.
+// It is posted directly, since the worker reads it before its server starts.
+func (wk *Worker) init(config WorkerConfig) (err error) {
+	msg := map[string]any{"module": nil}
+
+	if !config.CompileInWorker {
+		module, err := compiledModule(moduleSource(config))
+		if err != nil {
+			return fmt.Errorf("error initializing worker: %w", err)
+		}
+		msg["module"] = module
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("error initializing worker: %w", jsErr)
+		}
+	}()
+
+	wk.worker.Call("postMessage", msg)
+
+	return nil
+}
+
+// crashError returns the error for a Worker error event or a Node.js worker Error.
+func crashError(event js.Value) error {
+	msg := event.Get("message")
+	if msg.Type() != js.TypeString {
+		// A plain Event is dispatched when the worker script fails to load.
+		return fmt.Errorf("%w: worker script failed to load", ErrWorkerCrashed)
+	}
+
+	if filename := event.Get("filename"); filename.Type() == js.TypeString && filename.String() != "" {
+		return fmt.Errorf("%w: %s (%s:%d)", ErrWorkerCrashed, msg.String(), filename.String(), event.Get("lineno").Int())
+	}
+
+	return fmt.Errorf("%w: %s", ErrWorkerCrashed, msg.String())
+}
+
+// consoleLog logs to the JS console.
+func consoleLog(args ...any) {
+	jsutil.ConsoleLog(args...)
+}
@@ -0,0 +1,37 @@
+package wrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall/js"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpc"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func init() {
+	wrpc.Register("crash", func(ctx context.Context, _ io.Writer, _ io.Reader) error {
+		// An exception thrown by a JS callback is not recovered and crashes the worker.
+		js.Global().Call("setTimeout", js.Global().Get("Function").New("throw new Error('crashed')"), 0)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+func TestWorkerCrash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	worker, err := wrpc.NewWorker("")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer worker.Close()
+
+	w, _ := wrpcnet.Pipe()
+	_, r := wrpcnet.Pipe()
+
+	err = worker.Call(context.Background(), w, r, wrpc.Fn("crash"))
+	g.Expect(errors.Is(err, wrpc.ErrWorkerCrashed)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("crashed"))
+}
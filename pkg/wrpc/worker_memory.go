@@ -0,0 +1,225 @@
+//go:build !js
+
+package wrpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+)
+
+// Worker runs the functions registered in the calling process in goroutines.
+// Outside js/wasm, it stands in for a Web Worker so that call chains and
+// handlers can be tested with go test.
+//
+// The worker accepts as many concurrent calls as DefaultServer.
+type Worker struct {
+	dispatcher     *dispatcher
+	session        *Session
+	maxConcurrency int
+	startupTime    time.Duration
+
+	mu     sync.Mutex
+	calls  int
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Close stops the worker. A call in progress returns ErrWorkerClosed
+// and its handler is cancelled.
+func (wk *Worker) Close() {
+	wk.once.Do(func() {
+		close(wk.closed)
+		wk.dispatcher.cancelAll()
+		wk.session.close(ErrWorkerClosed)
+		wk.dispatcher.session.close(ErrWorkerClosed)
+	})
+}
+
+// MaxConcurrency returns the maximum number of concurrent calls the worker accepts.
+func (wk *Worker) MaxConcurrency() int {
+	return wk.maxConcurrency
+}
+
+// Load returns the number of calls in progress on the worker.
+func (wk *Worker) Load() int {
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	return wk.calls
+}
+
+// Session returns the session with the worker.
+func (wk *Worker) Session() *Session {
+	return wk.session
+}
+
+// StartupTime returns the time it took to create the worker.
+func (wk *Worker) StartupTime() time.Duration {
+	return wk.startupTime
+}
+
+// Call synchronously executes a call on the worker.
+// It returns when the function has finished.
+//
+// If ctx is cancelled before that, the function's context is cancelled
+// and Call waits for the function to return before returning ctx.Err().
+//
+// The call carries Metadata with the arguments of fn and the deadline of ctx.
+//
+// If the call cannot be dispatched, w and r are closed with the error.
+func (wk *Worker) Call(ctx context.Context, w, r *wrpcnet.MessagePort, fn Func) error {
+	if err := ctx.Err(); err != nil {
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	md, err := newMetadata(ctx, fn)
+	if err != nil {
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	name := fn.Name
+
+	if wk.isClosed() {
+		err := fmt.Errorf("error dispatching call '%s': %w", name, ErrWorkerClosed)
+		w.Abort(err)
+		r.Abort(err)
+		return err
+	}
+
+	f, ok := funcs[name]
+	if !ok {
+		err := fmt.Errorf("%w: '%s'", ErrFuncNotFound, name)
+		w.CloseWithError(err)
+		r.CloseWithError(err)
+		return err
+	}
+
+	wk.track(1)
+	defer wk.track(-1)
+
+	done := make(chan struct{})
+	wk.dispatcher.dispatch(md, md.CallID, name, f, w, r, func() {
+		close(done)
+	})
+
+	select {
+	case <-done:
+		return nil
+	case <-wk.closed:
+		return fmt.Errorf("error waiting for call '%s': %w", name, ErrWorkerClosed)
+	case <-ctx.Done():
+		wk.dispatcher.cancel(md.CallID)
+
+		select {
+		case <-done:
+		case <-wk.closed:
+			return fmt.Errorf("error waiting for call '%s': %w", name, ErrWorkerClosed)
+		}
+
+		return ctx.Err()
+	}
+}
+
+func (wk *Worker) track(n int) {
+	wk.mu.Lock()
+	wk.calls += n
+	wk.mu.Unlock()
+}
+
+func (wk *Worker) isClosed() bool {
+	select {
+	case <-wk.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Ping checks that the worker has not been closed.
+func (wk *Worker) Ping(ctx context.Context) error {
+	return wk.request(ctx, "ping")
+}
+
+// Funcs returns the functions registered in the process, sorted by name.
+func (wk *Worker) Funcs(ctx context.Context) ([]FuncInfo, error) {
+	if err := wk.request(ctx, "funcs"); err != nil {
+		return nil, err
+	}
+
+	return registeredFuncs(), nil
+}
+
+// request checks that a control request can be served.
+func (wk *Worker) request(ctx context.Context, kind string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if wk.isClosed() {
+		return fmt.Errorf("error sending %s request: %w", kind, ErrWorkerClosed)
+	}
+
+	return nil
+}
+
+// NewWorker creates an in-memory worker. The url is ignored.
+func NewWorker(url string) (*Worker, error) {
+	return newWorker(url, WorkerConfig{})
+}
+
+// SpawnWorker creates an in-memory worker. The config is ignored.
+func SpawnWorker(config WorkerConfig) (*Worker, error) {
+	return newWorker("", config)
+}
+
+func newWorker(string, WorkerConfig) (*Worker, error) {
+	start := time.Now()
+	limit := DefaultServer.concurrency()
+
+	parent, worker := newSessionPair()
+
+	wk := &Worker{
+		dispatcher:     newDispatcher(limit, worker),
+		session:        parent,
+		maxConcurrency: limit,
+		closed:         make(chan struct{}),
+	}
+	wk.startupTime = time.Since(start)
+
+	return wk, nil
+}
+
+// newSessionPair returns the sessions of the parent and of an in-memory worker,
+// which deliver their messages to each other directly.
+func newSessionPair() (parent, worker *Session) {
+	parent = newSession(func(msg sessionMessage) error {
+		worker.receive(msg)
+		return nil
+	})
+	worker = newSession(func(msg sessionMessage) error {
+		parent.receive(msg)
+		return nil
+	})
+
+	return parent, worker
+}
+
+// ListenAndServe returns an error outside js/wasm, where the functions
+// are served by in-memory workers instead.
+func (s *Server) ListenAndServe() error {
+	return fmt.Errorf("wrpc: ListenAndServe requires a js/wasm worker")
+}
+
+// consoleLog logs to the standard logger.
+func consoleLog(args ...any) {
+	log.Println(args...)
+}
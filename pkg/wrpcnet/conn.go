@@ -0,0 +1,130 @@
+package wrpcnet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Addr is the address of a Conn or a Listener.
+type Addr string
+
+// Network returns the network name.
+func (Addr) Network() string {
+	return "messageport"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+var connID int64
+
+// Conn is a net.Conn on top of a MessagePort.
+type Conn struct {
+	*MessagePort
+	local  Addr
+	remote Addr
+}
+
+var _ net.Conn = &Conn{}
+
+// NewConn creates a net.Conn on top of port.
+func NewConn(port *MessagePort, local, remote Addr) *Conn {
+	return &Conn{
+		MessagePort: port,
+		local:       local,
+		remote:      remote,
+	}
+}
+
+// Dial connects to a Listener on the other side of port. The connection uses
+// a new MessageChannel whose remote end is transferred through port.
+//
+// Both ends of the connection use DefaultStreamWindow.
+func Dial(port *MessagePort) (*Conn, error) {
+	local, remote := Pipe()
+	local.SetWindow(DefaultStreamWindow)
+
+	id := atomic.AddInt64(&connID, 1)
+	conn := transferable(remote)
+	msg := map[string]any{
+		"conn": conn,
+		"id":   id,
+	}
+
+	if err := port.WriteMessage(msg, []any{conn}); err != nil {
+		local.Close()
+		return nil, fmt.Errorf("wrpcnet: error dialing: %w", err)
+	}
+
+	return NewConn(local, Addr(fmt.Sprintf("client:%d", id)), Addr(fmt.Sprintf("server:%d", id))), nil
+}
+
+// LocalAddr returns the local address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the remote address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Listener is a net.Listener that accepts connections dialed through a MessagePort.
+//
+// Inside a worker, a port received from the main thread can be listened on
+// to serve net/http or other network protocols:
+//
+//	http.Serve(wrpcnet.Listen(port), handler)
+type Listener struct {
+	port *MessagePort
+	addr Addr
+}
+
+var _ net.Listener = &Listener{}
+
+// Listen returns a Listener that accepts connections dialed with Dial
+// on the other side of port.
+func Listen(port *MessagePort) *Listener {
+	return &Listener{
+		port: port,
+		addr: "listener",
+	}
+}
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		data, err := l.port.ReadMessage()
+		if err != nil {
+			if errors.Is(err, io.ErrClosedPipe) {
+				return nil, net.ErrClosed
+			}
+			return nil, err
+		}
+
+		p, err := data.Get("conn").Port()
+		if err != nil {
+			continue
+		}
+
+		id, _ := data.Get("id").Int()
+
+		p.SetWindow(DefaultStreamWindow)
+
+		return NewConn(p, Addr(fmt.Sprintf("server:%d", id)), Addr(fmt.Sprintf("client:%d", id))), nil
+	}
+}
+
+// Close closes the listener. Connections that have been accepted are not closed.
+func (l *Listener) Close() error {
+	return l.port.Close()
+}
+
+// Addr returns the listener's address.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
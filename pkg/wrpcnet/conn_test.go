@@ -0,0 +1,141 @@
+package wrpcnet_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func TestConnHTTP(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	l := wrpcnet.Listen(p2)
+	defer l.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	})
+
+	go http.Serve(l, mux)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return wrpcnet.Dial(p1)
+			},
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://worker/hello?name=gopher")
+		g.Expect(err).NotTo(HaveOccurred())
+
+		b, err := io.ReadAll(resp.Body)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(resp.Body.Close()).To(Succeed())
+		g.Expect(string(b)).To(Equal("hello gopher"))
+	}
+}
+
+func TestConnHalfClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	l := wrpcnet.Listen(p2)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := conn.Write(b); err != nil {
+			panic(err)
+		}
+	}()
+
+	conn, err := wrpcnet.Dial(p1)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(conn.CloseWrite()).To(Succeed())
+
+	_, err = conn.Write([]byte("ping"))
+	g.Expect(err).To(MatchError(io.ErrClosedPipe))
+
+	b, err := io.ReadAll(conn)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("ping"))
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	l := wrpcnet.Listen(p2)
+	defer l.Close()
+
+	conn, err := wrpcnet.Dial(p1)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+
+	g.Expect(conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))).To(Succeed())
+
+	_, err = conn.Read(make([]byte, 1))
+	g.Expect(errors.Is(err, os.ErrDeadlineExceeded)).To(BeTrue())
+
+	// Extending the deadline makes the conn readable again.
+	g.Expect(conn.SetReadDeadline(time.Time{})).To(Succeed())
+
+	server, err := l.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+	defer server.Close()
+
+	_, err = server.Write([]byte{1})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	buf := make([]byte, 1)
+	n, err := conn.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(buf[:n]).To(Equal([]byte{1}))
+}
+
+func TestListenerClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, p2 := wrpcnet.Pipe()
+	l := wrpcnet.Listen(p2)
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+
+	g.Expect(l.Close()).To(Succeed())
+	g.Eventually(accepted).Should(Receive(MatchError(net.ErrClosed)))
+}
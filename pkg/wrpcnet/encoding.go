@@ -0,0 +1,138 @@
+package wrpcnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compresses the messages written into a port.
+type Compressor interface {
+	// Name identifies the compressor on the remote side.
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// Flate compresses messages using compress/flate at BestSpeed.
+var Flate Compressor = &flateCompressor{}
+
+// MaxDecompressedSize is the largest message that Flate decompresses.
+// Messages that decompress to more fail to decode.
+var MaxDecompressedSize = 64 * 1024 * 1024
+
+// RegisterCompressor registers a compressor so that ports can decompress
+// messages compressed with it. Flate is registered by default.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	compressors[c.Name()] = c
+}
+
+// LookupCompressor returns a registered compressor.
+func LookupCompressor(name string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("wrpcnet: unknown compressor '%s'", name)
+	}
+
+	return c, nil
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		Flate.Name(): Flate,
+	}
+)
+
+// Encoding configures how the data written with Write is sent.
+// Like the Window, it is a property of the writer: the remote side
+// decodes the messages according to how they are tagged.
+//
+// Encoding does not apply to ports with a shared Window.
+type Encoding struct {
+	// ChunkSize is the maximum size of a message. Larger writes are split
+	// into multiple messages which the remote side reassembles, so that
+	// ReadBytes still returns the data of a single Write.
+	// Zero means no limit.
+	ChunkSize int
+
+	// Compressor compresses each message. Messages that do not get
+	// smaller are sent uncompressed. Nil means no compression.
+	Compressor Compressor
+}
+
+// Stats are the byte counters of a port.
+type Stats struct {
+	// BytesWritten is the number of bytes written with Write.
+	BytesWritten int64
+	// WireBytesWritten is the number of bytes sent for them after encoding.
+	WireBytesWritten int64
+	// BytesRead is the number of decoded bytes received by Read, ReadBytes and Receive.
+	BytesRead int64
+	// WireBytesRead is the number of bytes received for them before decoding.
+	WireBytesRead int64
+}
+
+type flateCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (*flateCompressor) Name() string { return "flate" }
+
+func (c *flateCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		zw.Reset(&buf)
+	} else {
+		var err error
+		if zw, err = flate.NewWriter(&buf, flate.BestSpeed); err != nil {
+			return nil, err
+		}
+	}
+	defer c.writers.Put(zw)
+
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(b []byte) ([]byte, error) {
+	zr, ok := c.readers.Get().(io.ReadCloser)
+	if ok {
+		if err := zr.(flate.Resetter).Reset(bytes.NewReader(b), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		zr = flate.NewReader(bytes.NewReader(b))
+	}
+	defer c.readers.Put(zr)
+
+	limit := MaxDecompressedSize
+	b, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > limit {
+		return nil, fmt.Errorf("wrpcnet: decompressed message exceeds %d bytes", limit)
+	}
+
+	return b, nil
+}
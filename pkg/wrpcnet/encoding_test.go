@@ -0,0 +1,96 @@
+package wrpcnet_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func TestEncodingChunks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 4})
+	p1.SetEncoding(wrpcnet.Encoding{ChunkSize: 10})
+
+	messages := [][]byte{bytes.Repeat([]byte("a"), 25), {}, []byte("b")}
+
+	go func() {
+		for _, b := range messages {
+			if _, err := p1.Write(b); err != nil {
+				panic(err)
+			}
+		}
+		p1.Close()
+	}()
+
+	// The chunks are reassembled.
+	for _, expected := range messages {
+		b, err := p2.ReadBytes()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(b).To(Equal(expected))
+	}
+
+	_, err := p2.ReadBytes()
+	g.Expect(err).To(MatchError(io.EOF))
+
+	g.Expect(p1.Stats()).To(Equal(wrpcnet.Stats{BytesWritten: 26, WireBytesWritten: 26}))
+	g.Expect(p2.Stats()).To(Equal(wrpcnet.Stats{BytesRead: 26, WireBytesRead: 26}))
+}
+
+func TestEncodingCompression(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+	p1.SetEncoding(wrpcnet.Encoding{ChunkSize: 1000, Compressor: wrpcnet.Flate})
+
+	data := bytes.Repeat([]byte("hello world "), 1000)
+
+	go func() {
+		if _, err := p1.Write(data); err != nil {
+			panic(err)
+		}
+		// Incompressible data is sent as is.
+		if _, err := p1.Write([]byte{1}); err != nil {
+			panic(err)
+		}
+		p1.Close()
+	}()
+
+	b, err := io.ReadAll(p2)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal(append(data, 1)))
+
+	written := p1.Stats()
+	g.Expect(written.BytesWritten).To(Equal(int64(len(data) + 1)))
+	g.Expect(written.WireBytesWritten).To(BeNumerically("<", len(data)/4))
+
+	read := p2.Stats()
+	g.Expect(read.BytesRead).To(Equal(written.BytesWritten))
+	g.Expect(read.WireBytesRead).To(Equal(written.WireBytesWritten))
+}
+
+func TestFlateDecompressLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	limit := wrpcnet.MaxDecompressedSize
+	defer func() { wrpcnet.MaxDecompressedSize = limit }()
+	wrpcnet.MaxDecompressedSize = 100
+
+	b, err := wrpcnet.Flate.Compress(bytes.Repeat([]byte("a"), 100))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	data, err := wrpcnet.Flate.Decompress(b)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(HaveLen(100))
+
+	b, err = wrpcnet.Flate.Compress(bytes.Repeat([]byte("a"), 101))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = wrpcnet.Flate.Decompress(b)
+	g.Expect(err).To(MatchError(ContainSubstring("exceeds 100 bytes")))
+}
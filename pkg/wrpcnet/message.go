@@ -0,0 +1,248 @@
+package wrpcnet
+
+import (
+	"context"
+	"fmt"
+)
+
+// Kind is the kind of a received Message.
+type Kind int
+
+// Message kinds.
+const (
+	KindBytes  Kind = iota // byte array written with Write, or an ArrayBuffer
+	KindNull               // null or undefined
+	KindBool               // boolean
+	KindNumber             // number
+	KindString             // string
+	KindPort               // MessagePort
+	KindObject             // any other value: objects, arrays, typed arrays, ImageBitmap, OffscreenCanvas
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindBytes:
+		return "bytes"
+	case KindNull:
+		return "null"
+	case KindBool:
+		return "bool"
+	case KindNumber:
+		return "number"
+	case KindString:
+		return "string"
+	case KindPort:
+		return "port"
+	case KindObject:
+		return "object"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Message is a message received with Receive or ReadMessage, or a field of one.
+//
+// Kind tells which accessor applies. The data of a KindBytes message is in Data.
+type Message struct {
+	Kind Kind
+	Data []byte
+
+	v value // the received value
+}
+
+// newValueMessage returns a message holding v.
+func newValueMessage(v value) Message {
+	m := Message{Kind: valueKind(v), v: v}
+	if m.Kind == KindBytes {
+		m.Data = valueBytes(v)
+	}
+
+	return m
+}
+
+// Has reports whether a KindObject message has the field key.
+func (m Message) Has(key string) bool {
+	if m.Kind != KindObject {
+		return false
+	}
+
+	_, ok := valueField(m.v, key)
+	return ok
+}
+
+// Get returns the field key of a KindObject message.
+// A missing field is a KindNull message.
+func (m Message) Get(key string) Message {
+	if m.Kind != KindObject {
+		return Message{Kind: KindNull}
+	}
+
+	v, ok := valueField(m.v, key)
+	if !ok {
+		return Message{Kind: KindNull}
+	}
+
+	return newValueMessage(v)
+}
+
+// Bool returns the value of a KindBool message.
+func (m Message) Bool() (bool, error) {
+	if err := m.expect(KindBool); err != nil {
+		return false, err
+	}
+	return valueBool(m.v), nil
+}
+
+// Float returns the value of a KindNumber message.
+func (m Message) Float() (float64, error) {
+	if err := m.expect(KindNumber); err != nil {
+		return 0, err
+	}
+	return valueFloat(m.v), nil
+}
+
+// Int returns the value of a KindNumber message truncated to an int.
+func (m Message) Int() (int, error) {
+	f, err := m.Float()
+	return int(f), err
+}
+
+// Text returns the value of a KindString message.
+func (m Message) Text() (string, error) {
+	if err := m.expect(KindString); err != nil {
+		return "", err
+	}
+	return valueText(m.v), nil
+}
+
+// Bytes returns the data of a KindBytes message.
+func (m Message) Bytes() ([]byte, error) {
+	if err := m.expect(KindBytes); err != nil {
+		return nil, err
+	}
+	return m.Data, nil
+}
+
+// Port returns a MessagePort wrapping the port of a KindPort message.
+func (m Message) Port() (*MessagePort, error) {
+	if err := m.expect(KindPort); err != nil {
+		return nil, err
+	}
+	return valuePort(m.v), nil
+}
+
+func (m Message) expect(kind Kind) error {
+	if m.Kind != kind {
+		return fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedMessage, kind, m.Kind)
+	}
+	return nil
+}
+
+// ReadMessage reads a single message or error from the port.
+// The message is a KindObject holding the fields written with WriteMessage.
+//
+// Messages sent before the remote side closed the port are read
+// before the error.
+func (p *MessagePort) ReadMessage() (Message, error) {
+	return p.ReadMessageContext(context.Background())
+}
+
+// ReadMessageContext is like ReadMessage but returns ctx.Err() when ctx is done.
+// Deadlines set with SetReadDeadline return os.ErrDeadlineExceeded.
+func (p *MessagePort) ReadMessageContext(ctx context.Context) (Message, error) {
+	msg, err := p.readMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{Kind: KindObject, v: msg.value()}, nil
+}
+
+// Send writes a structured-clone-able value into the port.
+//
+// v can be a string, a number, a bool, nil, a *MessagePort, or a []any
+// or map[string]any of them. On js/wasm, it can also be a js.Value such as
+// a typed array, an ImageBitmap, an OffscreenCanvas or a MessagePort.
+// The values in transfer are transferred instead of copied.
+//
+// Send blocks like WriteMessage. The remote side reads the value with Receive.
+func (p *MessagePort) Send(v any, transfer ...any) error {
+	val, err := newValue(v)
+	if err != nil {
+		return fmt.Errorf("wrpcnet: error sending %T: %w", v, err)
+	}
+
+	transferables := make([]any, len(transfer))
+	for i, t := range transfer {
+		if port, ok := t.(*MessagePort); ok {
+			t = transferable(port)
+		}
+		transferables[i] = t
+	}
+
+	return p.WriteMessage(map[string]any{"val": val}, transferables)
+}
+
+// SendPort transfers port to the remote side, which receives it with ReceivePort
+// as a live MessagePort. Messages that have not been read from port yet
+// are received by the new owner.
+//
+// The port must not have received messages locally, for they would be lost.
+// After SendPort, operations on port return ErrTransferred, even if the send fails.
+func (p *MessagePort) SendPort(port *MessagePort) error {
+	if err := port.detach(); err != nil {
+		return err
+	}
+
+	return p.Send(port, port)
+}
+
+// ReceivePort receives a port sent with SendPort.
+func (p *MessagePort) ReceivePort() (*MessagePort, error) {
+	msg, err := p.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Port()
+}
+
+// Receive reads the next message from the port, whether it was written
+// with Write or Send. If a byte array message has been partially consumed
+// by Read, its remainder is returned.
+//
+// Data written into a port with a shared Window must be read with Read or ReadBytes.
+func (p *MessagePort) Receive() (Message, error) {
+	if len(p.buf) > 0 {
+		b := p.buf
+		p.buf = nil
+		return Message{Kind: KindBytes, Data: b}, nil
+	}
+
+	for {
+		msg, err := p.readMessage(context.Background())
+		if err != nil {
+			return Message{}, err
+		}
+
+		if p.receiveRing(msg) {
+			continue
+		}
+
+		if msg.has("arr") {
+			b, complete, err := p.decode(msg)
+			if err != nil {
+				return Message{}, err
+			}
+
+			if !complete {
+				continue
+			}
+
+			return Message{Kind: KindBytes, Data: b}, nil
+		}
+
+		v, _ := valueField(msg.value(), "val")
+		return newValueMessage(v), nil
+	}
+}
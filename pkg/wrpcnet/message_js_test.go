@@ -0,0 +1,29 @@
+package wrpcnet_test
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/array"
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func TestSendTransfer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	arr := array.NewFromSlice([]float32{1, 2, 3})
+	g.Expect(p1.Send(arr.Value, arr.ArrayBuffer())).To(Succeed())
+
+	// The buffer has been transferred.
+	g.Expect(arr.ByteLength()).To(Equal(0))
+
+	msg, err := p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindObject))
+	g.Expect(msg.JSValue().InstanceOf(js.Global().Get("Float32Array"))).To(BeTrue())
+	g.Expect(msg.JSValue().Index(2).Float()).To(Equal(3.0))
+}
@@ -0,0 +1,168 @@
+package wrpcnet_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func TestSendReceive(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	g.Expect(p1.Send("hello")).To(Succeed())
+	g.Expect(p1.Send(42)).To(Succeed())
+	g.Expect(p1.Send(true)).To(Succeed())
+	g.Expect(p1.Send(nil)).To(Succeed())
+	g.Expect(p1.Send(map[string]any{"a": map[string]any{"b": "c"}, "d": []any{1}})).To(Succeed())
+	_, err := p1.Write([]byte{1, 2})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	msg, err := p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindString))
+	g.Expect(msg.Text()).To(Equal("hello"))
+
+	msg, err = p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Float()).To(Equal(42.0))
+
+	msg, err = p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Bool()).To(BeTrue())
+
+	msg, err = p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindNull))
+
+	msg, err = p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindObject))
+	g.Expect(msg.Has("d")).To(BeTrue())
+	g.Expect(msg.Has("e")).To(BeFalse())
+	g.Expect(msg.Get("a").Get("b").Text()).To(Equal("c"))
+	g.Expect(msg.Get("e").Kind).To(Equal(wrpcnet.KindNull))
+
+	_, err = msg.Text()
+	g.Expect(errors.Is(err, wrpcnet.ErrUnexpectedMessage)).To(BeTrue())
+
+	msg, err = p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Bytes()).To(Equal([]byte{1, 2}))
+}
+
+func TestSendPortValue(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	local, remote := wrpcnet.Pipe()
+	g.Expect(p1.Send(remote, remote)).To(Succeed())
+
+	msg, err := p2.Receive()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindPort))
+
+	port, err := msg.Port()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	go local.Write([]byte("over the transferred port"))
+
+	b, err := port.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(b)).To(Equal("over the transferred port"))
+}
+
+func TestReadMessageFields(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	g.Expect(p1.WriteMessage(map[string]any{"id": 7, "name": "x"}, nil)).To(Succeed())
+
+	msg, err := p2.ReadMessage()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Kind).To(Equal(wrpcnet.KindObject))
+	g.Expect(msg.Get("id").Int()).To(Equal(7))
+	g.Expect(msg.Get("name").Text()).To(Equal("x"))
+
+	_, err = msg.Get("id").Text()
+	g.Expect(errors.Is(err, wrpcnet.ErrUnexpectedMessage)).To(BeTrue())
+
+	_, err = p1.Write([]byte("data"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	msg, err = p2.ReadMessage()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(msg.Get("arr").Bytes()).To(Equal([]byte("data")))
+}
+
+func TestReadUnexpectedMessage(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	g.Expect(p1.Send("hello")).To(Succeed())
+	g.Expect(p1.Send(make(chan int))).NotTo(Succeed())
+
+	_, err := p2.Read(make([]byte, 10))
+	g.Expect(errors.Is(err, wrpcnet.ErrUnexpectedMessage)).To(BeTrue())
+}
+
+func TestReadCoalesceUnexpectedMessage(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 16})
+
+	_, err := p1.Write([]byte("ab"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(p1.Send("hello")).To(Succeed())
+	_, err = p1.Write([]byte("cd"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Wait for the messages to arrive so that Read coalesces them.
+	time.Sleep(10 * time.Millisecond)
+
+	buf := make([]byte, 16)
+	n, err := p2.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("ab"))
+
+	// The message that is not a byte array is reported by the next read.
+	_, err = p2.Read(buf)
+	g.Expect(errors.Is(err, wrpcnet.ErrUnexpectedMessage)).To(BeTrue())
+
+	n, err = p2.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("cd"))
+}
+
+func TestSendPortBuffered(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, _ := wrpcnet.Pipe()
+	local, remote := wrpcnet.Pipe()
+	local.SetWindow(wrpcnet.Window{Messages: 16})
+
+	for i := 0; i < 2; i++ {
+		_, err := local.Write([]byte{byte(i)})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	_, err := remote.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Wait for the second message to be received locally.
+	time.Sleep(20 * time.Millisecond)
+
+	g.Expect(p1.SendPort(remote)).NotTo(Succeed())
+}
@@ -0,0 +1,446 @@
+package wrpcnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// ErrStreamReset is returned from operations on a stream that was reset.
+var ErrStreamReset = errors.New("wrpcnet: stream reset")
+
+// DefaultStreamWindow is the flow control window of new streams.
+var DefaultStreamWindow = Window{Messages: 16, Bytes: 1024 * 1024}
+
+// Mux multiplexes bidirectional byte streams over a single MessagePort.
+//
+// Each stream has its own flow control window, so a stream whose reader
+// is slow does not block the other streams.
+type Mux struct {
+	port   *MessagePort
+	nextID int
+
+	mu       sync.Mutex
+	streams  map[int]*MuxStream
+	accepted []*MuxStream
+	err      error
+	changed  chan struct{} // closed when the state of the mux or a stream changes
+}
+
+// NewMux creates a multiplexer over port. The two sides of the port must pass
+// different values of client so that the IDs of the streams they open do not collide.
+//
+// The mux takes over reading from and writing to port.
+func NewMux(port *MessagePort, client bool) *Mux {
+	// Flow control is done per stream.
+	port.SetWindow(Window{Messages: math.MaxInt32})
+
+	m := &Mux{
+		port:    port,
+		nextID:  2,
+		streams: map[int]*MuxStream{},
+		changed: make(chan struct{}),
+	}
+
+	if client {
+		m.nextID = 1
+	}
+
+	go m.receive()
+
+	return m
+}
+
+// Open opens a new stream.
+func (m *Mux) Open() (*MuxStream, error) {
+	m.mu.Lock()
+
+	if err := m.err; err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	s := m.newStream(m.nextID)
+	m.nextID += 2
+	m.mu.Unlock()
+
+	if err := m.send(s.id, "open", nil); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Accept waits for the remote side to open a stream.
+func (m *Mux) Accept() (*MuxStream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if len(m.accepted) > 0 {
+			s := m.accepted[0]
+			m.accepted = m.accepted[1:]
+			return s, nil
+		}
+
+		if m.err != nil {
+			return nil, m.err
+		}
+
+		m.wait()
+	}
+}
+
+// Close closes the mux and the underlying port. All streams return io.ErrClosedPipe.
+func (m *Mux) Close() error {
+	m.fail(io.ErrClosedPipe)
+	return m.port.Close()
+}
+
+// newStream registers a stream. It must be called with m.mu held.
+func (m *Mux) newStream(id int) *MuxStream {
+	s := &MuxStream{
+		mux:    m,
+		id:     id,
+		window: DefaultStreamWindow,
+	}
+	m.streams[id] = s
+
+	return s
+}
+
+// send writes a frame into the port.
+func (m *Mux) send(id int, kind string, fields map[string]any) error {
+	msg := map[string]any{"s": id, "t": kind}
+	for k, v := range fields {
+		msg[k] = v
+	}
+
+	var transferables []any
+	if ab, ok := msg["arr"]; ok {
+		transferables = []any{ab}
+	}
+
+	return m.port.WriteMessage(msg, transferables)
+}
+
+// receive dispatches the frames from the port to the streams.
+func (m *Mux) receive() {
+	for {
+		data, err := m.port.readMessage(context.Background())
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		id := data.number("s")
+		kind := data.text("t")
+
+		var discarded bool
+
+		m.mu.Lock()
+
+		s, ok := m.streams[id]
+		switch {
+		case kind == "open" && !ok:
+			s = m.newStream(id)
+			m.accepted = append(m.accepted, s)
+
+		case !ok:
+			// The stream has been reset.
+
+		case kind == "data":
+			if s.closed {
+				discarded = true
+				break
+			}
+			b, err := data.bytes()
+			if err != nil {
+				m.mu.Unlock()
+				m.fail(err)
+				return
+			}
+			s.messages = append(s.messages, b)
+
+		case kind == "ack":
+			for n := data.number("n"); n > 0 && len(s.inflight) > 0; n-- {
+				s.inflightBytes -= s.inflight[0]
+				s.inflight = s.inflight[1:]
+			}
+
+		case kind == "fin":
+			s.remoteFin = true
+			if s.readErr == nil {
+				s.readErr = io.EOF
+			}
+			m.release(s)
+
+		case kind == "rst":
+			err := fmt.Errorf("%w: %s", ErrStreamReset, data.text("err"))
+			s.reset(err)
+			delete(m.streams, id)
+		}
+
+		m.notify()
+		m.mu.Unlock()
+
+		if discarded {
+			// Keep the remote side's credit flowing.
+			if err := m.send(id, "ack", map[string]any{"n": 1}); err != nil {
+				m.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail closes all streams with err.
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return
+	}
+
+	m.err = err
+	for id, s := range m.streams {
+		s.reset(err)
+		delete(m.streams, id)
+	}
+	m.notify()
+}
+
+// release removes a stream that has been closed on both sides. It must be called with m.mu held.
+func (m *Mux) release(s *MuxStream) {
+	if s.localFin && s.remoteFin {
+		delete(m.streams, s.id)
+	}
+}
+
+// wait waits for the state of the mux to change. It must be called with m.mu held.
+func (m *Mux) wait() {
+	changed := m.changed
+	m.mu.Unlock()
+	<-changed
+	m.mu.Lock()
+}
+
+// notify wakes up the goroutines waiting for the state of the mux to change.
+// It must be called with m.mu held.
+func (m *Mux) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// MuxStream is a bidirectional byte stream multiplexed over a MessagePort.
+//
+// Read has the same buffered semantics as MessagePort.Read.
+type MuxStream struct {
+	mux *Mux
+	id  int
+
+	// The fields are guarded by mux.mu.
+	window        Window
+	messages      [][]byte
+	buf           []byte // unread remainder of a message consumed by Read
+	unacked       int
+	inflight      []int
+	inflightBytes int
+	readErr       error
+	writeErr      error
+	closed        bool
+	localFin      bool
+	remoteFin     bool
+}
+
+// ID returns the stream ID.
+func (s *MuxStream) ID() int {
+	return s.id
+}
+
+// SetWindow sets the flow control window for writes into the stream.
+func (s *MuxStream) SetWindow(w Window) {
+	if w.Messages < 1 {
+		w.Messages = 1
+	}
+
+	s.mux.mu.Lock()
+	s.window = w
+	s.mux.notify()
+	s.mux.mu.Unlock()
+}
+
+// Read reads bytes from the stream.
+// It returns io.EOF after the remote side has closed its write side.
+func (s *MuxStream) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	m := s.mux
+	m.mu.Lock()
+
+	for len(s.buf) == 0 && len(s.messages) == 0 {
+		if s.closed {
+			m.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+
+		if s.readErr != nil {
+			err := s.readErr
+			m.mu.Unlock()
+			return 0, err
+		}
+
+		m.wait()
+	}
+
+	n := 0
+	for n < len(b) {
+		if len(s.buf) == 0 {
+			if len(s.messages) == 0 {
+				break
+			}
+			s.buf = s.messages[0]
+			s.messages = s.messages[1:]
+			s.unacked++
+		}
+
+		k := copy(b[n:], s.buf)
+		n += k
+		s.buf = s.buf[k:]
+	}
+
+	// Acknowledge in batches, but always when caught up with the writer.
+	var ack int
+	if len(s.messages) == 0 || s.unacked >= maxAckBatch {
+		ack = s.unacked
+		s.unacked = 0
+	}
+
+	m.mu.Unlock()
+
+	if ack > 0 {
+		if err := m.send(s.id, "ack", map[string]any{"n": ack}); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Write writes b into the stream as a single message.
+// It blocks while the data in flight exceeds the stream's window.
+func (s *MuxStream) Write(b []byte) (int, error) {
+	m := s.mux
+	m.mu.Lock()
+
+	for {
+		if s.writeErr != nil {
+			err := s.writeErr
+			m.mu.Unlock()
+			return 0, err
+		}
+
+		full := len(s.inflight) >= s.window.Messages ||
+			(s.window.Bytes > 0 && s.inflightBytes > 0 && s.inflightBytes+len(b) > s.window.Bytes)
+		if !full {
+			break
+		}
+
+		m.wait()
+	}
+
+	s.inflight = append(s.inflight, len(b))
+	s.inflightBytes += len(b)
+	m.mu.Unlock()
+
+	ab := newArrayBuffer(b)
+	if err := m.send(s.id, "data", map[string]any{"arr": ab}); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// CloseWrite closes the write side of the stream. The remote side reads io.EOF
+// after the data written before. The stream can still be read from.
+func (s *MuxStream) CloseWrite() error {
+	m := s.mux
+	m.mu.Lock()
+
+	if s.localFin || s.writeErr != nil {
+		m.mu.Unlock()
+		return nil
+	}
+
+	s.localFin = true
+	s.writeErr = io.ErrClosedPipe
+	m.release(s)
+	m.notify()
+	m.mu.Unlock()
+
+	return m.send(s.id, "fin", nil)
+}
+
+// Close closes the write side of the stream and stops reading from it.
+// Unread data and data received after Close are discarded and acknowledged,
+// so that the remote side's writes do not block.
+func (s *MuxStream) Close() error {
+	m := s.mux
+	m.mu.Lock()
+	ack := len(s.messages) + s.unacked
+	s.closed = true
+	s.messages = nil
+	s.buf = nil
+	s.unacked = 0
+	m.notify()
+	m.mu.Unlock()
+
+	if ack > 0 {
+		if err := m.send(s.id, "ack", map[string]any{"n": ack}); err != nil {
+			return err
+		}
+	}
+
+	return s.CloseWrite()
+}
+
+// Reset aborts both sides of the stream. Pending and future operations on the stream
+// return an error wrapping ErrStreamReset on both sides.
+func (s *MuxStream) Reset(err error) error {
+	if err == nil {
+		err = errors.New("reset by peer")
+	}
+
+	m := s.mux
+	m.mu.Lock()
+
+	if _, ok := m.streams[s.id]; !ok {
+		m.mu.Unlock()
+		return nil
+	}
+
+	s.reset(fmt.Errorf("%w: %s", ErrStreamReset, err.Error()))
+	delete(m.streams, s.id)
+	m.notify()
+	m.mu.Unlock()
+
+	return m.send(s.id, "rst", map[string]any{"err": err.Error()})
+}
+
+// reset fails the pending and future operations with err. It must be called with mux.mu held.
+func (s *MuxStream) reset(err error) {
+	if s.readErr == nil || s.readErr == io.EOF {
+		s.readErr = err
+	}
+	if s.writeErr == nil || s.localFin {
+		s.writeErr = err
+	}
+	s.messages = nil
+	s.buf = nil
+}
@@ -0,0 +1,166 @@
+package wrpcnet_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func newMuxPair() (*wrpcnet.Mux, *wrpcnet.Mux) {
+	p1, p2 := wrpcnet.Pipe()
+	return wrpcnet.NewMux(p1, true), wrpcnet.NewMux(p2, false)
+}
+
+func TestMuxHalfClose(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	client, server := newMuxPair()
+	defer client.Close()
+
+	go func() {
+		for {
+			s, err := server.Accept()
+			if err != nil {
+				return
+			}
+
+			// Echo until the client closes its write side.
+			go func() {
+				b, err := io.ReadAll(s)
+				if err != nil {
+					panic(err)
+				}
+				if _, err := s.Write(b); err != nil {
+					panic(err)
+				}
+				s.CloseWrite()
+			}()
+		}
+	}()
+
+	for _, msg := range []string{"first", "second"} {
+		s, err := client.Open()
+		g.Expect(err).NotTo(HaveOccurred())
+
+		_, err = s.Write([]byte(msg))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(s.CloseWrite()).To(Succeed())
+
+		_, err = s.Write([]byte(msg))
+		g.Expect(err).To(MatchError(io.ErrClosedPipe))
+
+		b, err := io.ReadAll(s)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(b)).To(Equal(msg))
+	}
+}
+
+func TestMuxStreamFlowControl(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	client, server := newMuxPair()
+	defer client.Close()
+
+	slow, err := client.Open()
+	g.Expect(err).NotTo(HaveOccurred())
+	slow.SetWindow(wrpcnet.Window{Messages: 1})
+
+	fast, err := client.Open()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	slowRemote, err := server.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+	fastRemote, err := server.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = slow.Write([]byte{1})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := slow.Write([]byte{2})
+		written <- err
+	}()
+
+	// The slow stream is blocked but the fast stream is not.
+	_, err = fast.Write([]byte{3})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	buf := make([]byte, 1)
+	_, err = fastRemote.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(buf).To(Equal([]byte{3}))
+
+	g.Consistently(written, 50*time.Millisecond).ShouldNot(Receive())
+
+	_, err = slowRemote.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(buf).To(Equal([]byte{1}))
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
+
+func TestMuxReset(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	client, server := newMuxPair()
+	defer client.Close()
+
+	s, err := client.Open()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	remote, err := server.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(remote.Reset(errors.New("stop"))).To(Succeed())
+
+	_, err = s.Read(make([]byte, 1))
+	g.Expect(errors.Is(err, wrpcnet.ErrStreamReset)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("stop"))
+
+	_, err = s.Write([]byte{1})
+	g.Expect(errors.Is(err, wrpcnet.ErrStreamReset)).To(BeTrue())
+
+	// Other streams are not affected.
+	s2, err := client.Open()
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = s2.Write([]byte{1})
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestMuxStreamCloseUnread(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	client, server := newMuxPair()
+	defer client.Close()
+
+	s, err := client.Open()
+	g.Expect(err).NotTo(HaveOccurred())
+	s.SetWindow(wrpcnet.Window{Messages: 2})
+
+	remote, err := server.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for i := 0; i < 2; i++ {
+		_, err := s.Write([]byte{byte(i)})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	// Wait for the messages to be received by the remote stream.
+	time.Sleep(20 * time.Millisecond)
+
+	// Closing without reading frees the window.
+	g.Expect(remote.Close()).To(Succeed())
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := s.Write([]byte{2})
+		written <- err
+	}()
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
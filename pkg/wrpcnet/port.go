@@ -2,187 +2,867 @@ package wrpcnet
 
 import (
 	"container/list"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"runtime"
-	"syscall/js"
-
-	"github.com/mgnsk/go-wasm-demos/pkg/array"
-	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+	"os"
+	"sync"
+	"time"
 )
 
+// ErrUnexpectedMessage is returned when a message of an unexpected kind is read,
+// such as a value sent with Send being read with Read.
+var ErrUnexpectedMessage = errors.New("wrpcnet: unexpected message")
+
+// ErrTransferred is returned from operations on a port that has been sent with SendPort.
+var ErrTransferred = errors.New("wrpcnet: port transferred")
+
+// DefaultWindow is the flow control window of new ports. It allows a single message
+// in flight, so that WriteMessage blocks until the remote side reads the message.
+var DefaultWindow = Window{Messages: 1}
+
+// Window limits the data a writer may have in flight, that is, written
+// but not yet read by the remote side.
+//
+// WriteMessage returns as soon as the data in flight fits in the window,
+// so with a window larger than one message, a successful write only means
+// that the message was sent, not that it was read.
+type Window struct {
+	// Messages is the maximum number of messages in flight
+	// before WriteMessage blocks. Values less than 1 mean 1.
+	Messages int
+
+	// Bytes is the maximum number of ArrayBuffer bytes in flight
+	// before WriteMessage blocks. Zero means no limit.
+	Bytes int
+
+	// Shared makes Write send the data through a SharedArrayBuffer ring buffer
+	// of Bytes bytes (DefaultSharedBufferSize if zero) instead of posting
+	// a message per Write. Write then blocks only while the ring buffer is full.
+	//
+	// The remote side must read the data with Read or ReadBytes.
+	// If shared memory is unavailable, the port falls back to messages.
+	Shared bool
+}
+
+// inflightMessage is a message that has not been acknowledged.
+type inflightMessage struct {
+	size int
+	sent time.Time
+}
+
+// maxAckBatch is the maximum number of messages a reader acknowledges at once.
+const maxAckBatch = 64
+
+// transport is the message channel under a MessagePort. On js/wasm it is
+// a JS MessagePort or Worker, elsewhere an in-memory channel.
+type transport interface {
+	// post sends a message, transferring the values in transferables.
+	post(messages map[string]any, transferables []any) error
+
+	// listen starts delivering the received messages to p.receive
+	// and the channel errors to p.close.
+	listen(p *MessagePort)
+
+	// close closes the local end of the channel.
+	close()
+}
+
 // MessagePort is a synchronous JS MessagePort wrapper.
+//
+// The remote side acknowledges the messages it has read in batches
+// and the writer blocks when the messages in flight exceed the port's Window.
 type MessagePort struct {
-	Value    js.Value
-	messages *list.List
-	notify   chan struct{}
-	ack      chan struct{}
-	done     chan struct{}
-	err      error
-}
-
-// Pipe returns a synchronous duplex MessagePort pipe.
-func Pipe() (*MessagePort, *MessagePort) {
-	ch := js.Global().Get("MessageChannel").New()
-	p1 := NewMessagePort(ch.Get("port1"))
-	p2 := NewMessagePort(ch.Get("port2"))
-	return p1, p2
-}
-
-// NewMessagePort creates a synchronous JS MessagePort wrapper.
-func NewMessagePort(value js.Value) *MessagePort {
-	p := &MessagePort{
-		Value:    value,
-		messages: list.New(),
-		notify:   make(chan struct{}),
-		ack:      make(chan struct{}),
-		done:     make(chan struct{}),
-	}
-
-	onError := js.FuncOf(p.onError)
-	onMessageError := js.FuncOf(p.onError)
-	onMessage := js.FuncOf(p.onMessage)
-
-	value.Set("onerror", onError)
-	value.Set("onmessageerror", onMessageError)
-	value.Set("onmessage", onMessage)
-
-	runtime.SetFinalizer(p, func(any) {
-		onError.Release()
-		onMessageError.Release()
-		onMessage.Release()
+	handle
+	t      transport
+	window Window
+
+	mu            sync.Mutex
+	messages      *list.List
+	unacked       int // messages read but not acknowledged
+	inflight      []inflightMessage
+	inflightBytes int
+	remoteErr     error // terminal error sent by the remote side
+	remoteFin     bool  // the remote side has closed its write side
+	writeClosed   bool
+	changed       chan struct{} // closed when the port state changes
+	readDeadline  *deadline
+	writeDeadline *deadline
+	sendRing      *ring       // ring buffer used by Write
+	recvRing      *ringReader // ring buffer announced by the remote side
+	encoding      Encoding
+	stats         Stats
+	tracer        Tracer
+
+	err     error
+	listen  sync.Once
+	buf     []byte // unread remainder of a message consumed by Read
+	readErr error  // error of a message coalesced by Read, returned by the next read
+	partial []byte // chunks of a message that has not been received completely
+}
+
+// newMessagePort creates a port with DefaultWindow over t.
+func newMessagePort(t transport) *MessagePort {
+	return &MessagePort{
+		t:             t,
+		window:        DefaultWindow,
+		messages:      list.New(),
+		changed:       make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+}
+
+// SetWindow sets the flow control window for writes into the port.
+// The window is a property of the writer; the remote side needs no configuration.
+func (p *MessagePort) SetWindow(w Window) {
+	if w.Messages < 1 {
+		w.Messages = 1
+	}
+
+	p.mu.Lock()
+	p.window = w
+	p.notify()
+	p.mu.Unlock()
+}
+
+// Window returns the flow control window for writes into the port.
+func (p *MessagePort) Window() Window {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.window
+}
+
+// start starts receiving messages.
+func (p *MessagePort) start() {
+	p.listen.Do(func() {
+		p.t.listen(p)
 	})
+}
+
+// readMessage reads a single message or error from the port.
+func (p *MessagePort) readMessage(ctx context.Context) (message, error) {
+	p.start()
 
-	return p
+	for {
+		p.mu.Lock()
+
+		if err := p.err; err != nil {
+			p.mu.Unlock()
+			return message{}, err
+		}
+
+		if p.messages.Len() > 0 {
+			msg := p.shift()
+			p.mu.Unlock()
+			p.trace(Event{Type: EventReceived, Size: msg.size()})
+
+			return msg, nil
+		}
+
+		if err := p.remoteErr; err != nil {
+			p.mu.Unlock()
+			p.close(err)
+			continue
+		}
+
+		if p.remoteFin {
+			p.mu.Unlock()
+			return message{}, io.EOF
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-p.readDeadline.wait():
+			return message{}, os.ErrDeadlineExceeded
+		case <-ctx.Done():
+			return message{}, ctx.Err()
+		}
+	}
 }
 
-// ReadMessage reads a single message or error from the port.
-func (p *MessagePort) ReadMessage() (js.Value, error) {
-	select {
-	case <-p.done:
-		return js.Value{}, p.err
-	case <-p.notify:
-		p.Value.Call("postMessage", map[string]any{"__ack": true})
+// poll returns a message that has already been received without blocking.
+func (p *MessagePort) poll() (message, bool) {
+	p.mu.Lock()
+
+	if p.err != nil || p.messages.Len() == 0 {
+		p.mu.Unlock()
+		return message{}, false
+	}
+
+	msg := p.shift()
+	p.mu.Unlock()
+	p.trace(Event{Type: EventReceived, Size: msg.size()})
+
+	return msg, true
+}
 
-		msg := p.messages.Remove(p.messages.Front()).(js.Value)
-		jsutil.ConsoleLog("readMessage", msg)
+// shift removes the first received message. It must be called with p.mu held.
+func (p *MessagePort) shift() message {
+	msg := p.messages.Remove(p.messages.Front()).(message)
+	p.unacked++
 
-		return msg, nil
+	// Acknowledge in batches, but always when caught up with the writer
+	// so that a writer waiting for credit is unblocked.
+	if p.messages.Len() == 0 || p.unacked >= maxAckBatch {
+		p.t.post(map[string]any{"__ack": p.unacked}, nil)
+		p.unacked = 0
 	}
+
+	return msg
 }
 
 // WriteMessage writes a messages into the port.
-// It blocks until the remote side reads the message.
+// It blocks until the messages in flight fit in the port's Window.
+// With DefaultWindow, it blocks until the remote side reads the message.
 func (p *MessagePort) WriteMessage(messages map[string]any, transferables []any) error {
-	p.Value.Call("postMessage", messages, transferables)
-	select {
-	case <-p.done:
-		// jsutil.ConsoleLog("postMessage error", p.err.Error())
-		return p.err
-	case <-p.ack:
-		jsutil.ConsoleLog("postMessage ack")
-		return nil
+	return p.WriteMessageContext(context.Background(), messages, transferables)
+}
+
+// WriteMessageContext is like WriteMessage but returns ctx.Err() when ctx is done.
+// Deadlines set with SetWriteDeadline return os.ErrDeadlineExceeded.
+//
+// If the message has already been posted when ctx is done, it may still
+// be received by the remote side.
+func (p *MessagePort) WriteMessageContext(ctx context.Context, messages map[string]any, transferables []any) error {
+	p.start()
+
+	size := messageSize(messages)
+	start := time.Now()
+
+	p.mu.Lock()
+	err, closed := p.err, p.writeClosed
+	p.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if closed {
+		return io.ErrClosedPipe
+	}
+
+	if isClosed(p.writeDeadline.wait()) {
+		return os.ErrDeadlineExceeded
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := p.t.post(messages, transferables); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.inflight = append(p.inflight, inflightMessage{size: size, sent: start})
+	p.inflightBytes += size
+	p.mu.Unlock()
+
+	for {
+		p.mu.Lock()
+
+		if err := p.err; err != nil {
+			p.mu.Unlock()
+			return err
+		}
+
+		if err := p.remoteErr; err != nil {
+			p.mu.Unlock()
+			return err
+		}
+
+		full := len(p.inflight) >= p.window.Messages ||
+			(p.window.Bytes > 0 && p.inflightBytes > p.window.Bytes)
+		if !full {
+			p.mu.Unlock()
+			p.trace(Event{Type: EventSent, Size: size, Latency: time.Since(start)})
+			return nil
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-p.writeDeadline.wait():
+			return os.ErrDeadlineExceeded
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
-// Read a byte array message from the port.
+// messageSize returns the size of the ArrayBuffer in messages.
+func messageSize(messages map[string]any) int {
+	if ab, ok := messages["arr"]; ok {
+		return bufferSize(ab)
+	}
+	return 0
+}
+
+// Read reads bytes from the port.
+//
+// A message larger than b is partially consumed and the remainder is returned
+// by the next reads. Messages that have already been received are coalesced
+// into b, so Read blocks only when no data is buffered.
 func (p *MessagePort) Read(b []byte) (n int, err error) {
-	msg, err := p.ReadMessage()
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if len(p.buf) == 0 {
+		if err := p.takeReadErr(); err != nil {
+			return 0, err
+		}
+
+		buf, _, err := p.nextBytes(true)
+		if err != nil {
+			return 0, err
+		}
+		p.buf = buf
+	}
+
+	for {
+		k := copy(b[n:], p.buf)
+		n += k
+		p.buf = p.buf[k:]
+
+		if n == len(b) {
+			return n, nil
+		}
+
+		buf, ok, err := p.nextBytes(false)
+		if err != nil {
+			// Return the bytes read so far and the error with the next read.
+			p.readErr = err
+			return n, nil
+		}
+		if !ok {
+			return n, nil
+		}
+		p.buf = buf
+	}
+}
+
+// takeReadErr returns and clears the error of a message coalesced by Read.
+func (p *MessagePort) takeReadErr() error {
+	err := p.readErr
+	p.readErr = nil
+
+	return err
+}
+
+// ReadBytes reads a single byte array message from the port. If the message
+// has been partially consumed by Read, the remainder is returned.
+func (p *MessagePort) ReadBytes() ([]byte, error) {
+	if len(p.buf) > 0 {
+		b := p.buf
+		p.buf = nil
+		return b, nil
+	}
+
+	if err := p.takeReadErr(); err != nil {
+		return nil, err
+	}
+
+	b, _, err := p.nextBytes(true)
+
+	return b, err
+}
+
+// nextBytes returns the next byte array message, switching to the ring buffer
+// when the remote side announces one. If block is false, it reports false
+// instead of waiting for a message.
+func (p *MessagePort) nextBytes(block bool) ([]byte, bool, error) {
+	for {
+		p.mu.Lock()
+		rr := p.recvRing
+		p.mu.Unlock()
+
+		if rr != nil {
+			b, ok, err := p.readRing(rr, block)
+			if ok {
+				p.count(&p.stats.BytesRead, &p.stats.WireBytesRead, len(b), frameHeader+len(b))
+			}
+			return b, ok, err
+		}
+
+		var msg message
+		if block {
+			var err error
+			if msg, err = p.readMessage(context.Background()); err != nil {
+				return nil, false, err
+			}
+		} else {
+			var ok bool
+			if msg, ok = p.poll(); !ok {
+				return nil, false, nil
+			}
+		}
+
+		if p.receiveRing(msg) {
+			continue
+		}
+
+		b, complete, err := p.decode(msg)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if complete {
+			return b, true, nil
+		}
+	}
+}
+
+// decode decodes a byte array message according to its tags. It reports false
+// when the message is a chunk of a larger message that has not been received completely.
+func (p *MessagePort) decode(msg message) ([]byte, bool, error) {
+	b, err := msg.bytes()
 	if err != nil {
-		return 0, err
+		return nil, false, err
 	}
 
-	ab := msg.Get("arr")
-	if ab.IsUndefined() {
-		panic("expected an ArrayBuffer message")
+	wire := len(b)
+
+	if msg.has("z") {
+		c, err := LookupCompressor(msg.text("z"))
+		if err != nil {
+			return nil, false, err
+		}
+
+		if b, err = c.Decompress(b); err != nil {
+			return nil, false, fmt.Errorf("wrpcnet: error decompressing message: %w", err)
+		}
 	}
 
-	arr := array.NewUint8Array(ab)
-	if arr.Len() > len(b) {
-		p.messages.PushFront(msg)
+	p.count(&p.stats.BytesRead, &p.stats.WireBytesRead, len(b), wire)
 
-		return 0, io.ErrShortBuffer
+	if msg.flag("more") {
+		p.partial = append(p.partial, b...)
+		return nil, false, nil
 	}
 
-	return arr.CopyBytesToGo(b), nil
+	if p.partial != nil {
+		b = append(p.partial, b...)
+		p.partial = nil
+	}
+
+	return b, true, nil
+}
+
+// readRing reads the next frame from the ring buffer.
+func (p *MessagePort) readRing(rr *ringReader, block bool) ([]byte, bool, error) {
+	for {
+		if frame, ok := rr.next(); ok {
+			return frame, true, nil
+		}
+
+		if !block {
+			return nil, false, nil
+		}
+
+		// The remote side writes into the ring before it posts a close,
+		// so the ring is drained once more after a close has been observed.
+		p.mu.Lock()
+		err, remoteErr, remoteFin, changed := p.err, p.remoteErr, p.remoteFin, p.changed
+		p.mu.Unlock()
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if remoteErr != nil || remoteFin {
+			if frame, ok := rr.next(); ok {
+				return frame, true, nil
+			}
+
+			if remoteErr != nil {
+				p.close(remoteErr)
+				continue
+			}
+
+			return nil, false, io.EOF
+		}
+
+		select {
+		case <-changed:
+		case <-rr.wait(ringWritePos, rr.load(ringWritePos)):
+		case <-p.readDeadline.wait():
+			return nil, false, os.ErrDeadlineExceeded
+		}
+	}
 }
 
 // Write a byte array message into the port.
+//
+// The data is sent according to the port's Encoding.
 func (p *MessagePort) Write(b []byte) (n int, err error) {
-	ab := array.NewFromSlice(b).ArrayBuffer()
-	messages := map[string]any{"arr": ab}
-	transferables := []any{ab}
+	if r := p.sharedRing(); r != nil {
+		if err := p.writeRing(r, b); err != nil {
+			return 0, err
+		}
+		p.count(&p.stats.BytesWritten, &p.stats.WireBytesWritten, len(b), frameHeader+len(b))
+		return len(b), nil
+	}
+
+	enc := p.Encoding()
+
+	for {
+		chunk, more := b, false
+		if enc.ChunkSize > 0 && len(chunk) > enc.ChunkSize {
+			chunk, more = b[:enc.ChunkSize], true
+		}
+
+		if err := p.writeChunk(chunk, more, enc.Compressor); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		b = b[len(chunk):]
+
+		if !more {
+			return n, nil
+		}
+	}
+}
+
+// writeChunk writes a single byte array message.
+func (p *MessagePort) writeChunk(b []byte, more bool, c Compressor) error {
+	data := b
+	messages := map[string]any{}
+
+	if c != nil && len(b) > 0 {
+		z, err := c.Compress(b)
+		if err != nil {
+			return fmt.Errorf("wrpcnet: error compressing message: %w", err)
+		}
+
+		if len(z) < len(b) {
+			data = z
+			messages["z"] = c.Name()
+		}
+	}
+
+	if more {
+		messages["more"] = true
+	}
+
+	ab := newArrayBuffer(data)
+	messages["arr"] = ab
+
+	if err := p.WriteMessage(messages, []any{ab}); err != nil {
+		return err
+	}
+
+	p.count(&p.stats.BytesWritten, &p.stats.WireBytesWritten, len(b), len(data))
+
+	return nil
+}
+
+// SetEncoding sets the encoding of the data written with Write.
+func (p *MessagePort) SetEncoding(e Encoding) {
+	p.mu.Lock()
+	p.encoding = e
+	p.mu.Unlock()
+}
+
+// Encoding returns the encoding of the data written with Write.
+func (p *MessagePort) Encoding() Encoding {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.encoding
+}
+
+// Stats returns the byte counters of the port.
+func (p *MessagePort) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// count adds to a pair of logical and wire byte counters.
+func (p *MessagePort) count(logical, wire *int64, n, w int) {
+	p.mu.Lock()
+	*logical += int64(n)
+	*wire += int64(w)
+	p.mu.Unlock()
+}
+
+// sharedRing returns the ring buffer for writes, allocating it and announcing it
+// to the remote side on the first write into a port with a shared Window.
+func (p *MessagePort) sharedRing() *ring {
+	p.start()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sendRing != nil || !p.window.Shared || p.err != nil || p.writeClosed || !SharedMemoryAvailable() {
+		return p.sendRing
+	}
+
+	size := p.window.Bytes
+	if size <= 0 {
+		size = DefaultSharedBufferSize
+	}
+
+	r := newRing(size)
+	if err := p.t.post(map[string]any{"__ring": r.buffer}, nil); err != nil {
+		return nil
+	}
+
+	// The announcement is acknowledged like a message.
+	p.inflight = append(p.inflight, inflightMessage{sent: time.Now()})
+	p.sendRing = r
+
+	return r
+}
+
+// writeRing writes b into the ring buffer as a length-prefixed frame.
+// Frames larger than the ring are written in parts as the remote side reads them.
+func (p *MessagePort) writeRing(r *ring, b []byte) error {
+	var header [frameHeader]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(b)))
 
-	if err := p.WriteMessage(messages, transferables); err != nil {
-		return 0, err
+	headerWritten := false
+
+	for {
+		p.mu.Lock()
+		err, remoteErr, writeClosed, changed := p.err, p.remoteErr, p.writeClosed, p.changed
+		p.mu.Unlock()
+
+		switch {
+		case err != nil:
+			return err
+		case remoteErr != nil:
+			return remoteErr
+		case writeClosed:
+			return io.ErrClosedPipe
+		case isClosed(p.writeDeadline.wait()):
+			return os.ErrDeadlineExceeded
+		}
+
+		readPos := r.load(ringReadPos)
+
+		// The header is published at once so that the reader
+		// never sees a partial header.
+		if !headerWritten && r.free() >= frameHeader {
+			r.copyIn(header[:])
+			headerWritten = true
+		}
+
+		if headerWritten {
+			n := r.copyIn(b)
+			b = b[n:]
+
+			if len(b) == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-r.wait(ringReadPos, readPos):
+		case <-p.writeDeadline.wait():
+			return os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// detach closes the port locally without notifying the remote side,
+// so that its JS value can be transferred.
+func (p *MessagePort) detach() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
 	}
 
-	return len(b), nil
+	if p.messages.Len() > 0 || len(p.buf) > 0 || len(p.partial) > 0 || p.recvRing != nil || p.sendRing != nil {
+		return errors.New("wrpcnet: cannot transfer a port with buffered data")
+	}
+
+	p.err = ErrTransferred
+	p.notify()
+
+	return nil
+}
+
+// SetDeadline sets the read and write deadlines of the port.
+func (p *MessagePort) SetDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	p.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for pending and future reads.
+// Reads past the deadline return os.ErrDeadlineExceeded.
+// The zero value of t means reads do not time out.
+func (p *MessagePort) SetReadDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future writes.
+// Writes past the deadline return os.ErrDeadlineExceeded.
+// The zero value of t means writes do not time out.
+func (p *MessagePort) SetWriteDeadline(t time.Time) error {
+	p.writeDeadline.set(t)
+	return nil
 }
 
 // Close the port. All pending reads and writes are unblocked and return io.ErrClosedPipe.
 func (p *MessagePort) Close() error {
-	p.err = io.ErrClosedPipe
-	close(p.done)
-	p.Value.Call("postMessage", map[string]any{"__eof": true})
-	p.Value.Call("close")
+	if p.close(io.ErrClosedPipe) {
+		p.t.post(map[string]any{"__eof": true}, nil)
+	}
+	p.t.close()
 	return nil
 }
 
+// CloseWrite closes the write side of the port. The remote side reads io.EOF
+// after the messages written before, but the port can still be read from.
+func (p *MessagePort) CloseWrite() error {
+	p.start()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.writeClosed || p.err != nil {
+		return nil
+	}
+	p.writeClosed = true
+
+	return p.t.post(map[string]any{"__fin": true}, nil)
+}
+
 // CloseWithError writes an error message into the port and closes the port.
 // All pending reads and writes are unblocked and return io.ErrClosedPipe.
 func (p *MessagePort) CloseWithError(err error) {
-	p.err = io.ErrClosedPipe
-	close(p.done)
-	p.Value.Call("postMessage", map[string]any{"__err": err.Error()})
-	p.Value.Call("close")
+	if p.close(io.ErrClosedPipe) {
+		p.t.post(map[string]any{"__err": err.Error()}, nil)
+	}
+	p.t.close()
 }
 
-func (p *MessagePort) onError(_ js.Value, args []js.Value) any {
-	if p.err == nil {
-		p.err = js.Error{Value: args[0]}
-		close(p.done)
+// Abort writes an error message into the port and closes the port.
+// Unlike CloseWithError, all pending reads and writes are unblocked and return err.
+func (p *MessagePort) Abort(err error) {
+	if p.close(err) {
+		p.t.post(map[string]any{"__err": err.Error()}, nil)
 	}
-	return nil
+	p.t.close()
 }
 
-func (p *MessagePort) onMessage(this js.Value, args []js.Value) any {
-	data := args[0].Get("data")
-	eof := data.Get("__eof")
-	err := data.Get("__err")
-	ack := data.Get("__ack")
+// close closes the port locally with err. It reports whether the port was open.
+func (p *MessagePort) close(err error) bool {
+	p.mu.Lock()
+
+	if p.err != nil {
+		p.mu.Unlock()
+		return false
+	}
+	p.err = err
+	p.notify()
+	p.wakeRings()
+	p.mu.Unlock()
+
+	p.trace(Event{Type: EventClosed, Err: err})
+
+	return true
+}
 
+// receive handles a message received by the transport.
+func (p *MessagePort) receive(msg message) {
 	switch {
-	case !eof.IsUndefined():
-		if p.err == nil {
-			p.err = io.EOF
-			close(p.done)
-		}
+	case msg.has("__eof"):
+		p.closeRemote(io.EOF)
+
+	case msg.has("__fin"):
+		p.mu.Lock()
+		p.remoteFin = true
+		p.notify()
+		p.mu.Unlock()
+
+	case msg.has("__err"):
+		p.closeRemote(decodeError(msg.text("__err")))
+
+	case msg.has("__ack"):
+		var last inflightMessage
 
-	case !err.IsUndefined():
-		if p.err == nil {
-			p.err = errors.New(err.String())
-			close(p.done)
+		p.mu.Lock()
+		for n := msg.number("__ack"); n > 0 && len(p.inflight) > 0; n-- {
+			last = p.inflight[0]
+			p.inflightBytes -= last.size
+			p.inflight = p.inflight[1:]
 		}
+		p.notify()
+		p.mu.Unlock()
 
-	case !ack.IsUndefined():
-		go func() {
-			select {
-			case <-p.done:
-			case p.ack <- struct{}{}:
-			}
-		}()
+		p.trace(Event{Type: EventAcked, Messages: msg.number("__ack"), Latency: time.Since(last.sent)})
 
 	default:
-		p.messages.PushBack(data)
+		p.mu.Lock()
+		p.messages.PushBack(msg)
+		p.notify()
+		p.mu.Unlock()
+	}
+}
 
-		go func() {
-			select {
-			case <-p.done:
-			case p.notify <- struct{}{}:
-			}
-		}()
+// closeRemote records the terminal error sent by the remote side. Pending writes
+// return it immediately while reads return it after the queued messages.
+func (p *MessagePort) closeRemote(err error) {
+	p.mu.Lock()
+
+	if p.remoteErr != nil {
+		p.mu.Unlock()
+		return
 	}
+	p.remoteErr = err
+	p.notify()
+	p.wakeRings()
+	p.mu.Unlock()
 
-	return nil
+	p.trace(Event{Type: EventClosed, Err: err, Remote: true})
+}
+
+// wakeRings resolves the pending waits on the ring buffers so that
+// their callbacks are released. It must be called with p.mu held.
+func (p *MessagePort) wakeRings() {
+	if p.sendRing != nil {
+		p.sendRing.wake()
+	}
+	if p.recvRing != nil {
+		p.recvRing.wake()
+	}
+}
+
+// notify wakes up the goroutines waiting for the port state to change.
+// It must be called with p.mu held.
+func (p *MessagePort) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// decodeError restores well-known errors from their remote representation
+// so that they can be matched with errors.Is.
+func decodeError(msg string) error {
+	switch msg {
+	case context.Canceled.Error():
+		return context.Canceled
+	case context.DeadlineExceeded.Error():
+		return context.DeadlineExceeded
+	default:
+		return errors.New(msg)
+	}
 }
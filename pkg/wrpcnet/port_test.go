@@ -0,0 +1,363 @@
+package wrpcnet_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func TestWindow(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 3})
+
+	// Two messages fit in the window without the remote side reading.
+	for i := 0; i < 2; i++ {
+		_, err := p1.Write([]byte{byte(i)})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := p1.Write([]byte{2})
+		written <- err
+	}()
+
+	g.Consistently(written, 50*time.Millisecond).ShouldNot(Receive())
+
+	buf := make([]byte, 1)
+	for i := 0; i < 3; i++ {
+		n, err := p2.Read(buf)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(buf[:n]).To(Equal([]byte{byte(i)}))
+	}
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
+
+func TestWindowBytes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 100, Bytes: 4})
+
+	_, err := p1.Write([]byte{1, 2, 3, 4})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := p1.Write([]byte{5})
+		written <- err
+	}()
+
+	g.Consistently(written, 50*time.Millisecond).ShouldNot(Receive())
+
+	b, err := p2.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{1, 2, 3, 4}))
+
+	b, err = p2.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{5}))
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
+
+func TestWindowBatchedAcks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 200})
+
+	// More messages than the reader acknowledges at once.
+	for i := 0; i < 150; i++ {
+		_, err := p1.Write([]byte{byte(i)})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	for i := 0; i < 150; i++ {
+		b, err := p2.ReadBytes()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(b).To(Equal([]byte{byte(i)}))
+	}
+
+	// Every read message is acknowledged and the whole window is available.
+	// The last message that fits leaves the window full.
+	written := make(chan error, 1)
+	go func() {
+		for i := 0; i < 199; i++ {
+			if _, err := p1.Write([]byte{byte(i)}); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
+
+func TestCloseAfterWrites(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 10})
+
+	for i := 0; i < 3; i++ {
+		_, err := p1.Write([]byte{byte(i)})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(p1.Close()).To(Succeed())
+
+	// The messages sent before closing are read before io.EOF.
+	for i := 0; i < 3; i++ {
+		b, err := p2.ReadBytes()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(b).To(Equal([]byte{byte(i)}))
+	}
+
+	_, err := p2.ReadBytes()
+	g.Expect(err).To(MatchError(io.EOF))
+}
+
+func TestCloseWithError(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 10})
+
+	_, err := p1.Write([]byte{1})
+	g.Expect(err).NotTo(HaveOccurred())
+	p1.CloseWithError(errors.New("failed"))
+
+	// The remote side reads the error after the data written before.
+	b, err := p2.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{1}))
+
+	_, err = p2.ReadBytes()
+	g.Expect(err).To(MatchError("failed"))
+
+	_, err = p1.Write([]byte{2})
+	g.Expect(err).To(MatchError(io.ErrClosedPipe))
+}
+
+func TestAbort(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := p2.ReadBytes()
+		read <- err
+	}()
+
+	g.Consistently(read, 20*time.Millisecond).ShouldNot(Receive())
+
+	p1.Abort(context.Canceled)
+
+	// Both sides observe the cancellation.
+	g.Eventually(read).Should(Receive(MatchError(context.Canceled)))
+
+	_, err := p2.Write([]byte{1})
+	g.Expect(err).To(MatchError(context.Canceled))
+
+	_, err = p1.Write([]byte{1})
+	g.Expect(err).To(MatchError(context.Canceled))
+}
+
+func TestReadPartial(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+
+	go func() {
+		defer p1.Close()
+		if _, err := p1.Write([]byte("hello world")); err != nil {
+			panic(err)
+		}
+	}()
+
+	buf := make([]byte, 4)
+	var b []byte
+	for {
+		n, err := p2.Read(buf)
+		b = append(b, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(n).To(BeNumerically(">", 0))
+	}
+
+	g.Expect(string(b)).To(Equal("hello world"))
+}
+
+func TestReadCoalesce(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 10})
+
+	for _, s := range []string{"a", "bc", "def"} {
+		_, err := p1.Write([]byte(s))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	// Receive the first message and wait for the rest to arrive.
+	buf := make([]byte, 16)
+	n, err := p2.Read(buf[:1])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("a"))
+
+	time.Sleep(10 * time.Millisecond)
+
+	n, err = p2.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("bcdef"))
+}
+
+func TestReadDeadline(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 10})
+
+	g.Expect(p2.SetReadDeadline(time.Now().Add(20 * time.Millisecond))).To(Succeed())
+
+	_, err := p2.ReadMessage()
+	g.Expect(err).To(MatchError(os.ErrDeadlineExceeded))
+
+	// Messages received before the deadline are still read.
+	_, err = p1.Write([]byte{1})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(p2.SetReadDeadline(time.Time{})).To(Succeed())
+
+	b, err := p2.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{1}))
+}
+
+func TestWriteDeadline(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, _ := wrpcnet.Pipe()
+	g.Expect(p1.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))).To(Succeed())
+
+	// The remote side never reads the message.
+	_, err := p1.Write([]byte{1})
+	g.Expect(err).To(MatchError(os.ErrDeadlineExceeded))
+
+	_, err = p1.Write([]byte{2})
+	g.Expect(err).To(MatchError(os.ErrDeadlineExceeded))
+}
+
+func TestMessageContext(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := p2.ReadMessageContext(ctx)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = p1.WriteMessageContext(ctx, map[string]any{"n": 1}, nil)
+	g.Expect(err).To(MatchError(context.Canceled))
+}
+
+func TestSharedWindow(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Bytes: 16, Shared: true})
+
+	// The second message is larger than the ring buffer.
+	messages := [][]byte{[]byte("hello"), bytes.Repeat([]byte("x"), 100), {}, []byte("world")}
+
+	go func() {
+		for _, b := range messages {
+			if _, err := p1.Write(b); err != nil {
+				panic(err)
+			}
+		}
+		p1.Close()
+	}()
+
+	for _, expected := range messages {
+		b, err := p2.ReadBytes()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(b).To(Equal(expected))
+	}
+
+	_, err := p2.ReadBytes()
+	g.Expect(err).To(MatchError(io.EOF))
+}
+
+func TestSharedWindowRead(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Bytes: 64, Shared: true})
+
+	expected := make([]byte, 10000)
+	for i := range expected {
+		expected[i] = byte(i)
+	}
+
+	go func() {
+		for b := expected; len(b) > 0; b = b[100:] {
+			if _, err := p1.Write(b[:100]); err != nil {
+				panic(err)
+			}
+		}
+		p1.Close()
+	}()
+
+	b, err := io.ReadAll(p2)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal(expected))
+}
+
+func TestSharedWindowBuffered(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(wrpcnet.SharedMemoryAvailable()).To(BeTrue())
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Bytes: 16, Shared: true})
+
+	// Writes do not wait for the reader while the ring buffer has room.
+	written := make(chan error, 1)
+	go func() {
+		_, err := p1.Write([]byte{1, 2, 3})
+		written <- err
+	}()
+	g.Eventually(written).Should(Receive(BeNil()))
+
+	// A frame does not fit into the remaining space.
+	go func() {
+		_, err := p1.Write(make([]byte, 10))
+		written <- err
+	}()
+	g.Consistently(written, 50*time.Millisecond).ShouldNot(Receive())
+
+	b, err := p2.ReadBytes()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{1, 2, 3}))
+
+	g.Eventually(written).Should(Receive(BeNil()))
+}
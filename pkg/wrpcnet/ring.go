@@ -0,0 +1,147 @@
+package wrpcnet
+
+import "encoding/binary"
+
+// DefaultSharedBufferSize is the size of the ring buffer of a shared Window with zero Bytes.
+const DefaultSharedBufferSize = 1024 * 1024
+
+const (
+	ringWritePos = 0 // index of the write position in the ring state
+	ringReadPos  = 1 // index of the read position in the ring state
+
+	frameHeader = 4 // size of the length prefix of a frame
+)
+
+// ringSize rounds size up to a power of two.
+func ringSize(size int) int {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	return n
+}
+
+// copyIn copies as much of b into the ring as fits and publishes it.
+// It must only be called by the writer.
+func (r *ring) copyIn(b []byte) int {
+	w := r.load(ringWritePos)
+	free := r.size - (w - r.load(ringReadPos))
+
+	n := uint32(len(b))
+	if n > free {
+		n = free
+	}
+	if n == 0 {
+		return 0
+	}
+
+	start := w % r.size
+	first := n
+	if start+first > r.size {
+		first = r.size - start
+	}
+
+	r.put(start, b[:first])
+	if first < n {
+		r.put(0, b[first:n])
+	}
+
+	r.store(ringWritePos, w+n)
+
+	return int(n)
+}
+
+// copyOut copies as many buffered bytes into b as are available and releases them.
+// It must only be called by the reader.
+func (r *ring) copyOut(b []byte) int {
+	rd := r.load(ringReadPos)
+	used := r.load(ringWritePos) - rd
+
+	n := uint32(len(b))
+	if n > used {
+		n = used
+	}
+	if n == 0 {
+		return 0
+	}
+
+	start := rd % r.size
+	first := n
+	if start+first > r.size {
+		first = r.size - start
+	}
+
+	r.get(b[:first], start)
+	if first < n {
+		r.get(b[first:n], 0)
+	}
+
+	r.store(ringReadPos, rd+n)
+
+	return int(n)
+}
+
+// buffered returns the number of bytes available to the reader.
+func (r *ring) buffered() int {
+	return int(r.load(ringWritePos) - r.load(ringReadPos))
+}
+
+// free returns the number of bytes available to the writer.
+func (r *ring) free() int {
+	return int(r.size - (r.load(ringWritePos) - r.load(ringReadPos)))
+}
+
+// ringReader reassembles the length-prefixed frames written into a ring.
+type ringReader struct {
+	*ring
+	frame   []byte
+	n       int // bytes of frame received
+	inFrame bool
+}
+
+// next returns the next frame if it has been received completely.
+// Frames larger than the ring are received in parts.
+func (r *ringReader) next() ([]byte, bool) {
+	for {
+		if !r.inFrame {
+			// The writer publishes the header at once.
+			if r.buffered() < frameHeader {
+				return nil, false
+			}
+
+			var header [frameHeader]byte
+			r.copyOut(header[:])
+			r.frame = make([]byte, binary.LittleEndian.Uint32(header[:]))
+			r.n = 0
+			r.inFrame = true
+		}
+
+		if r.n < len(r.frame) {
+			k := r.copyOut(r.frame[r.n:])
+			if k == 0 {
+				return nil, false
+			}
+			r.n += k
+			continue
+		}
+
+		r.inFrame = false
+		return r.frame, true
+	}
+}
+
+// receiveRing switches reads to the ring buffer announced by msg.
+// It reports whether msg was an announcement.
+func (p *MessagePort) receiveRing(msg message) bool {
+	r, ok := announcedRing(msg)
+	if !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	p.recvRing = &ringReader{ring: r}
+	p.mu.Unlock()
+
+	return true
+}
@@ -0,0 +1,136 @@
+package wrpcnet
+
+import (
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	ringState = 8 // size of the ring state in bytes
+
+	// ringPollInterval is the interval at which the ring is polled
+	// when Atomics.waitAsync is not supported.
+	ringPollInterval = time.Millisecond
+)
+
+var (
+	sharedMemoryOnce      sync.Once
+	sharedMemoryAvailable bool
+)
+
+// SharedMemoryAvailable reports whether SharedArrayBuffer can be used.
+// In browsers, this requires the page to be cross-origin isolated.
+func SharedMemoryAvailable() bool {
+	sharedMemoryOnce.Do(func() {
+		if js.Global().Get("SharedArrayBuffer").Type() != js.TypeFunction {
+			return
+		}
+
+		isolated := js.Global().Get("crossOriginIsolated")
+		if isolated.Type() == js.TypeBoolean && !isolated.Bool() {
+			return
+		}
+
+		sharedMemoryAvailable = true
+	})
+
+	return sharedMemoryAvailable
+}
+
+var atomics = js.Global().Get("Atomics")
+
+// ring is a single-producer single-consumer ring buffer in a SharedArrayBuffer.
+//
+// The read and write positions are free-running uint32 counters stored
+// in an Int32Array at the start of the buffer. The data size is a power of two
+// so that the positions wrap around consistently.
+type ring struct {
+	buffer js.Value // SharedArrayBuffer
+	state  js.Value // Int32Array
+	data   js.Value // Uint8Array
+	size   uint32
+}
+
+// newRing allocates a ring buffer of at least size bytes.
+func newRing(size int) *ring {
+	return openRing(js.Global().Get("SharedArrayBuffer").New(ringState + ringSize(size)))
+}
+
+// openRing opens a ring buffer allocated by the remote side.
+func openRing(buffer js.Value) *ring {
+	return &ring{
+		buffer: buffer,
+		state:  js.Global().Get("Int32Array").New(buffer, 0, ringState/4),
+		data:   js.Global().Get("Uint8Array").New(buffer, ringState),
+		size:   uint32(buffer.Get("byteLength").Int() - ringState),
+	}
+}
+
+func (r *ring) load(i int) uint32 {
+	return uint32(atomics.Call("load", r.state, i).Int())
+}
+
+// store stores a position and wakes up the remote side waiting for it.
+func (r *ring) store(i int, v uint32) {
+	atomics.Call("store", r.state, i, int32(v))
+	atomics.Call("notify", r.state, i)
+}
+
+// wake wakes up all waiters.
+func (r *ring) wake() {
+	atomics.Call("notify", r.state, ringWritePos)
+	atomics.Call("notify", r.state, ringReadPos)
+}
+
+// wait returns a channel that is closed when the position at index i
+// may have changed from v.
+//
+// Atomics.wait would block the whole Go runtime, so the ring is waited on
+// with Atomics.waitAsync, or polled when that is not supported.
+func (r *ring) wait(i int, v uint32) <-chan struct{} {
+	ch := make(chan struct{})
+
+	if atomics.Get("waitAsync").Type() != js.TypeFunction {
+		time.AfterFunc(ringPollInterval, func() {
+			close(ch)
+		})
+		return ch
+	}
+
+	res := atomics.Call("waitAsync", r.state, i, int32(v))
+	if !res.Get("async").Bool() {
+		close(ch)
+		return ch
+	}
+
+	var f js.Func
+	f = js.FuncOf(func(js.Value, []js.Value) any {
+		f.Release()
+		close(ch)
+		return nil
+	})
+	res.Get("value").Call("then", f)
+
+	return ch
+}
+
+// put copies b into the ring data at offset start.
+func (r *ring) put(start uint32, b []byte) {
+	js.CopyBytesToJS(r.data.Call("subarray", start, start+uint32(len(b))), b)
+}
+
+// get copies the ring data at offset start into b.
+func (r *ring) get(b []byte, start uint32) {
+	js.CopyBytesToGo(b, r.data.Call("subarray", start, start+uint32(len(b))))
+}
+
+// announcedRing opens the ring buffer of a __ring message.
+func announcedRing(msg message) (*ring, bool) {
+	sab := msg.Get("__ring")
+	if sab.IsUndefined() {
+		return nil, false
+	}
+
+	return openRing(sab), true
+}
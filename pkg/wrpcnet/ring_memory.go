@@ -0,0 +1,102 @@
+//go:build !js
+
+package wrpcnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SharedMemoryAvailable reports whether SharedArrayBuffer can be used.
+// Outside js/wasm, the ports of a Pipe always share memory.
+func SharedMemoryAvailable() bool {
+	return true
+}
+
+// sharedBuffer is the in-memory counterpart of a SharedArrayBuffer.
+type sharedBuffer struct {
+	state [2]uint32 // accessed atomically
+	data  []byte
+
+	mu      sync.Mutex
+	changed chan struct{} // closed when a position is stored
+}
+
+// ring is a single-producer single-consumer ring buffer in a sharedBuffer.
+//
+// The read and write positions are free-running uint32 counters.
+// The data size is a power of two so that the positions wrap around consistently.
+type ring struct {
+	buffer *sharedBuffer
+	size   uint32
+}
+
+// newRing allocates a ring buffer of at least size bytes.
+func newRing(size int) *ring {
+	return openRing(&sharedBuffer{
+		data:    make([]byte, ringSize(size)),
+		changed: make(chan struct{}),
+	})
+}
+
+// openRing opens a ring buffer allocated by the remote side.
+func openRing(buffer *sharedBuffer) *ring {
+	return &ring{
+		buffer: buffer,
+		size:   uint32(len(buffer.data)),
+	}
+}
+
+func (r *ring) load(i int) uint32 {
+	return atomic.LoadUint32(&r.buffer.state[i])
+}
+
+// store stores a position and wakes up the remote side waiting for it.
+func (r *ring) store(i int, v uint32) {
+	atomic.StoreUint32(&r.buffer.state[i], v)
+	r.wake()
+}
+
+// wake wakes up all waiters.
+func (r *ring) wake() {
+	r.buffer.mu.Lock()
+	close(r.buffer.changed)
+	r.buffer.changed = make(chan struct{})
+	r.buffer.mu.Unlock()
+}
+
+// wait returns a channel that is closed when the position at index i
+// may have changed from v.
+func (r *ring) wait(i int, v uint32) <-chan struct{} {
+	r.buffer.mu.Lock()
+	ch := r.buffer.changed
+	r.buffer.mu.Unlock()
+
+	// A store after the load closes ch.
+	if r.load(i) != v {
+		ch = make(chan struct{})
+		close(ch)
+	}
+
+	return ch
+}
+
+// put copies b into the ring data at offset start.
+func (r *ring) put(start uint32, b []byte) {
+	copy(r.buffer.data[start:], b)
+}
+
+// get copies the ring data at offset start into b.
+func (r *ring) get(b []byte, start uint32) {
+	copy(b, r.buffer.data[start:])
+}
+
+// announcedRing opens the ring buffer of a __ring message.
+func announcedRing(msg message) (*ring, bool) {
+	buffer, ok := msg["__ring"].(*sharedBuffer)
+	if !ok {
+		return nil, false
+	}
+
+	return openRing(buffer), true
+}
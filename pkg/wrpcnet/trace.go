@@ -0,0 +1,189 @@
+package wrpcnet
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the type of a traced port event.
+type EventType int
+
+// Event types.
+const (
+	EventSent     EventType = iota // a message was written
+	EventReceived                  // a message was read
+	EventAcked                     // the remote side acknowledged messages
+	EventClosed                    // the port was closed locally or by the remote side
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSent:
+		return "sent"
+	case EventReceived:
+		return "received"
+	case EventAcked:
+		return "acked"
+	case EventClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a traced port event.
+type Event struct {
+	Type EventType
+	Port *MessagePort
+	Time time.Time
+
+	// Size is the number of ArrayBuffer bytes of a sent or received message.
+	Size int
+
+	// Messages is the number of messages acknowledged.
+	Messages int
+
+	// Latency is the time a sent message waited for the flow control window,
+	// or the time from sending the last acknowledged message until its acknowledgement.
+	Latency time.Duration
+
+	// Err is the error a port was closed with.
+	Err error
+
+	// Remote reports whether the port was closed by the remote side.
+	Remote bool
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventSent:
+		return fmt.Sprintf("wrpcnet: sent %d bytes, waited %s", e.Size, e.Latency)
+	case EventReceived:
+		return fmt.Sprintf("wrpcnet: received %d bytes", e.Size)
+	case EventAcked:
+		return fmt.Sprintf("wrpcnet: %d messages acked in %s", e.Messages, e.Latency)
+	case EventClosed:
+		if e.Remote {
+			return fmt.Sprintf("wrpcnet: closed by remote: %v", e.Err)
+		}
+		return fmt.Sprintf("wrpcnet: closed: %v", e.Err)
+	default:
+		return "wrpcnet: " + e.Type.String()
+	}
+}
+
+// Tracer receives port events. Trace is called synchronously
+// from the goroutine or JS event handler that caused the event,
+// so it must not block.
+type Tracer interface {
+	Trace(Event)
+}
+
+// TracerFunc is a function that implements Tracer.
+type TracerFunc func(Event)
+
+// Trace calls f(e).
+func (f TracerFunc) Trace(e Event) {
+	f(e)
+}
+
+// NopTracer discards the events. It is the default tracer.
+var NopTracer Tracer = nopTracer{}
+
+type nopTracer struct{}
+
+func (nopTracer) Trace(Event) {}
+
+// ConsoleTracer logs the events to the JS console, or to the standard logger
+// outside js/wasm.
+var ConsoleTracer Tracer = TracerFunc(func(e Event) {
+	consoleLog(e.String())
+})
+
+type tracerValue struct {
+	Tracer
+}
+
+var globalTracer atomic.Value
+
+func init() {
+	globalTracer.Store(tracerValue{NopTracer})
+}
+
+// SetTracer sets the tracer of the ports that have no tracer of their own.
+// A nil tracer means NopTracer.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = NopTracer
+	}
+	globalTracer.Store(tracerValue{t})
+}
+
+// RingTracer keeps the most recent events in memory for debugging.
+type RingTracer struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewRingTracer creates a tracer that keeps the last n events.
+func NewRingTracer(n int) *RingTracer {
+	if n < 1 {
+		n = 1
+	}
+
+	return &RingTracer{events: make([]Event, n)}
+}
+
+// Trace records an event, overwriting the oldest one when the tracer is full.
+func (t *RingTracer) Trace(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.events[t.next] = e
+	t.next = (t.next + 1) % len(t.events)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Events returns the recorded events, oldest first.
+func (t *RingTracer) Events() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.full {
+		return append([]Event(nil), t.events[:t.next]...)
+	}
+
+	return append(append([]Event(nil), t.events[t.next:]...), t.events[:t.next]...)
+}
+
+// SetTracer sets the tracer of the port. A nil tracer means the global tracer set with SetTracer.
+func (p *MessagePort) SetTracer(t Tracer) {
+	p.mu.Lock()
+	p.tracer = t
+	p.mu.Unlock()
+}
+
+// trace sends an event to the port's tracer. It must be called without p.mu held.
+func (p *MessagePort) trace(e Event) {
+	p.mu.Lock()
+	t := p.tracer
+	p.mu.Unlock()
+
+	if t == nil {
+		t = globalTracer.Load().(tracerValue).Tracer
+	}
+
+	if _, ok := t.(nopTracer); ok {
+		return
+	}
+
+	e.Port = p
+	e.Time = time.Now()
+	t.Trace(e)
+}
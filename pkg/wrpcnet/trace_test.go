@@ -0,0 +1,113 @@
+package wrpcnet_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/wrpcnet"
+	. "github.com/onsi/gomega"
+)
+
+func eventTypes(events []wrpcnet.Event) []wrpcnet.EventType {
+	types := make([]wrpcnet.EventType, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestTracer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	writer := wrpcnet.NewRingTracer(10)
+	reader := wrpcnet.NewRingTracer(10)
+	p1.SetTracer(writer)
+	p2.SetTracer(reader)
+
+	go func() {
+		if _, err := p1.Write([]byte{1, 2, 3}); err != nil {
+			panic(err)
+		}
+		p1.Close()
+	}()
+
+	b, err := io.ReadAll(p2)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(b).To(Equal([]byte{1, 2, 3}))
+
+	g.Eventually(func() []wrpcnet.EventType {
+		return eventTypes(writer.Events())
+	}).Should(Equal([]wrpcnet.EventType{wrpcnet.EventAcked, wrpcnet.EventSent, wrpcnet.EventClosed}))
+
+	events := writer.Events()
+	g.Expect(events[0].Messages).To(Equal(1))
+	g.Expect(events[1].Size).To(Equal(3))
+	g.Expect(events[1].Port).To(BeIdenticalTo(p1))
+
+	events = reader.Events()
+	g.Expect(eventTypes(events)).To(Equal([]wrpcnet.EventType{wrpcnet.EventReceived, wrpcnet.EventClosed, wrpcnet.EventClosed}))
+	g.Expect(events[0].Size).To(Equal(3))
+	g.Expect(events[1].Remote).To(BeTrue())
+	g.Expect(events[1].Err).To(MatchError(io.EOF))
+}
+
+func TestTracerCoalescedRead(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := wrpcnet.Pipe()
+	p1.SetWindow(wrpcnet.Window{Messages: 10})
+	tracer := wrpcnet.NewRingTracer(10)
+	p2.SetTracer(tracer)
+
+	for _, s := range []string{"a", "bc", "def"} {
+		_, err := p1.Write([]byte(s))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	// Receive the first message and wait for the rest to arrive.
+	buf := make([]byte, 16)
+	n, err := p2.Read(buf[:1])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("a"))
+
+	time.Sleep(10 * time.Millisecond)
+
+	// A single Read coalesces the rest.
+	n, err = p2.Read(buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(buf[:n])).To(Equal("bcdef"))
+
+	// Every message read is traced.
+	events := tracer.Events()
+	g.Expect(eventTypes(events)).To(Equal([]wrpcnet.EventType{wrpcnet.EventReceived, wrpcnet.EventReceived, wrpcnet.EventReceived}))
+	g.Expect(events[2].Size).To(Equal(3))
+}
+
+func TestRingTracerOverwrite(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tracer := wrpcnet.NewRingTracer(2)
+	for i := 1; i <= 3; i++ {
+		tracer.Trace(wrpcnet.Event{Size: i})
+	}
+
+	events := tracer.Events()
+	g.Expect(events).To(HaveLen(2))
+	g.Expect(events[0].Size).To(Equal(2))
+	g.Expect(events[1].Size).To(Equal(3))
+}
+
+func TestGlobalTracer(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tracer := wrpcnet.NewRingTracer(10)
+	wrpcnet.SetTracer(tracer)
+	defer wrpcnet.SetTracer(nil)
+
+	p1, _ := wrpcnet.Pipe()
+	p1.Close()
+
+	g.Expect(eventTypes(tracer.Events())).To(Equal([]wrpcnet.EventType{wrpcnet.EventClosed}))
+}
@@ -0,0 +1,259 @@
+package wrpcnet
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall/js"
+
+	"github.com/mgnsk/go-wasm-demos/pkg/array"
+	"github.com/mgnsk/go-wasm-demos/pkg/jsutil"
+)
+
+// handle is the JS object under a port.
+type handle struct {
+	// Value is the JS MessagePort, Worker or worker global scope.
+	Value js.Value
+}
+
+// message is a message received from a JS object.
+type message struct {
+	js.Value
+}
+
+func (m message) value() value {
+	return m.Value
+}
+
+func (m message) has(key string) bool {
+	return !m.Get(key).IsUndefined()
+}
+
+func (m message) number(key string) int {
+	return m.Get(key).Int()
+}
+
+func (m message) text(key string) string {
+	return m.Get(key).String()
+}
+
+func (m message) flag(key string) bool {
+	return m.Get(key).Truthy()
+}
+
+// size returns the size of the ArrayBuffer of a byte array message.
+func (m message) size() int {
+	if ab := m.Get("arr"); ab.Type() == js.TypeObject {
+		return ab.Get("byteLength").Int()
+	}
+	return 0
+}
+
+// bytes copies the ArrayBuffer of a byte array message into Go.
+func (m message) bytes() ([]byte, error) {
+	ab := m.Get("arr")
+	if ab.IsUndefined() {
+		return nil, fmt.Errorf("%w: expected a byte array, got %s", ErrUnexpectedMessage, valueKind(m.Get("val")))
+	}
+
+	return copyArrayBuffer(ab), nil
+}
+
+// jsTransport posts messages through a JS object with a postMessage method.
+type jsTransport struct {
+	value js.Value
+}
+
+// post posts a message, returning any exception thrown by JS
+// (such as a DataCloneError) as an error.
+func (t jsTransport) post(messages map[string]any, transferables []any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = jsErr
+		}
+	}()
+
+	t.value.Call("postMessage", messages, transferables)
+
+	return nil
+}
+
+// listen installs the event handlers on the JS object.
+//
+// A Node.js worker_threads Worker is an EventEmitter that passes the data
+// to its message listeners instead of a MessageEvent.
+func (t jsTransport) listen(p *MessagePort) {
+	emitter := t.value.Get("addEventListener").Type() != js.TypeFunction &&
+		t.value.Get("on").Type() == js.TypeFunction
+
+	onError := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		p.close(js.Error{Value: args[0]})
+		return nil
+	})
+	onMessage := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		data := args[0]
+		if !emitter {
+			data = data.Get("data")
+		}
+		p.receive(message{data})
+		return nil
+	})
+
+	if emitter {
+		t.value.Call("on", "error", onError)
+		t.value.Call("on", "messageerror", onError)
+		t.value.Call("on", "message", onMessage)
+	} else {
+		t.value.Set("onerror", onError)
+		t.value.Set("onmessageerror", onError)
+		t.value.Set("onmessage", onMessage)
+	}
+
+	runtime.SetFinalizer(p, func(any) {
+		onError.Release()
+		onMessage.Release()
+	})
+}
+
+// close closes the JS object. Worker objects have no close method.
+func (t jsTransport) close() {
+	if t.value.Get("close").Type() == js.TypeFunction {
+		t.value.Call("close")
+	}
+}
+
+// Pipe returns a synchronous duplex MessagePort pipe.
+func Pipe() (*MessagePort, *MessagePort) {
+	ch := js.Global().Get("MessageChannel").New()
+	p1 := NewMessagePort(ch.Get("port1"))
+	p2 := NewMessagePort(ch.Get("port2"))
+	return p1, p2
+}
+
+// NewMessagePort creates a synchronous JS MessagePort wrapper with DefaultWindow.
+//
+// The port starts receiving messages when it is first read from or written to,
+// so a port that is transferred to another context without being used
+// does not lose messages.
+func NewMessagePort(value js.Value) *MessagePort {
+	p := newMessagePort(jsTransport{value: value})
+	p.Value = value
+	return p
+}
+
+// bufferSize returns the size of an ArrayBuffer in a message to be posted.
+func bufferSize(v any) int {
+	if ab, ok := v.(js.Value); ok && ab.Type() == js.TypeObject {
+		return ab.Get("byteLength").Int()
+	}
+	return 0
+}
+
+// newArrayBuffer copies b into a new ArrayBuffer.
+func newArrayBuffer(b []byte) any {
+	return array.NewFromSlice(b).ArrayBuffer()
+}
+
+// copyArrayBuffer copies an ArrayBuffer into Go.
+func copyArrayBuffer(ab js.Value) []byte {
+	arr := array.NewUint8Array(ab)
+	b := make([]byte, arr.Len())
+	arr.CopyBytesToGo(b)
+
+	return b
+}
+
+// value is a received JS value.
+type value = js.Value
+
+// valueKind returns the kind of a received value.
+func valueKind(v js.Value) Kind {
+	switch v.Type() {
+	case js.TypeNull, js.TypeUndefined:
+		return KindNull
+	case js.TypeBoolean:
+		return KindBool
+	case js.TypeNumber:
+		return KindNumber
+	case js.TypeString:
+		return KindString
+	}
+
+	if port := js.Global().Get("MessagePort"); port.Type() == js.TypeFunction && v.InstanceOf(port) {
+		return KindPort
+	}
+
+	if v.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		return KindBytes
+	}
+
+	return KindObject
+}
+
+// valueField returns the field key of an object. It reports false if the field is undefined.
+func valueField(v js.Value, key string) (js.Value, bool) {
+	f := v.Get(key)
+	return f, !f.IsUndefined()
+}
+
+func valueBool(v js.Value) bool {
+	return v.Bool()
+}
+
+func valueFloat(v js.Value) float64 {
+	return v.Float()
+}
+
+func valueText(v js.Value) string {
+	return v.String()
+}
+
+// valueBytes copies an ArrayBuffer into Go.
+func valueBytes(v js.Value) []byte {
+	return copyArrayBuffer(v)
+}
+
+func valuePort(v js.Value) *MessagePort {
+	return NewMessagePort(v)
+}
+
+// newValue converts v with js.ValueOf, replacing a *MessagePort with its JS value.
+func newValue(v any) (val js.Value, err error) {
+	defer func() {
+		// js.ValueOf panics with a string for values it cannot convert.
+		if r := recover(); r != nil {
+			msg, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(msg)
+		}
+	}()
+
+	if port, ok := v.(*MessagePort); ok {
+		v = transferable(port)
+	}
+
+	return js.ValueOf(v), nil
+}
+
+// transferable returns the JS value to transfer port with.
+func transferable(port *MessagePort) any {
+	return port.Value
+}
+
+// JSValue returns the received JS value of a message, so that values such as
+// an ImageBitmap or an OffscreenCanvas can be passed to JS APIs.
+// It is undefined for byte arrays written with Write.
+func (m Message) JSValue() js.Value {
+	return m.v
+}
+
+// consoleLog logs to the JS console.
+func consoleLog(s string) {
+	jsutil.ConsoleLog(s)
+}
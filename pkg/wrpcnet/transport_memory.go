@@ -0,0 +1,291 @@
+//go:build !js
+
+package wrpcnet
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// handle is empty outside js/wasm.
+type handle struct{}
+
+// message is a message received from an in-memory channel.
+type message map[string]any
+
+func (m message) value() value {
+	return map[string]any(m)
+}
+
+func (m message) has(key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func (m message) number(key string) int {
+	n, _ := m[key].(int)
+	return n
+}
+
+func (m message) text(key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func (m message) flag(key string) bool {
+	b, _ := m[key].(bool)
+	return b
+}
+
+// size returns the size of the data of a byte array message.
+func (m message) size() int {
+	b, _ := m["arr"].([]byte)
+	return len(b)
+}
+
+// bytes returns the data of a byte array message.
+func (m message) bytes() ([]byte, error) {
+	b, ok := m["arr"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a byte array", ErrUnexpectedMessage)
+	}
+
+	return b, nil
+}
+
+// memTransport is one end of an in-memory message channel.
+//
+// Like a JS MessagePort, it queues the messages until it is listened to
+// and delivers them asynchronously, in order, from its own goroutine.
+// Messages posted to a closed end are dropped.
+type memTransport struct {
+	peer *memTransport
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []message
+	port    *MessagePort
+	running bool
+	closed  bool
+}
+
+func newMemTransport() *memTransport {
+	t := &memTransport{}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// post queues a message on the remote end. The values of the message
+// are passed by reference, so transferables need not be listed.
+func (t *memTransport) post(messages map[string]any, _ []any) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	peer := t.peer
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if !peer.closed {
+		peer.queue = append(peer.queue, message(messages))
+		peer.cond.Broadcast()
+	}
+
+	return nil
+}
+
+// listen starts delivering the queued messages to p.
+func (t *memTransport) listen(p *MessagePort) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.port = p
+	t.cond.Broadcast()
+
+	if !t.running {
+		t.running = true
+		go t.deliver()
+	}
+}
+
+// unlisten stops delivering messages so that they are queued for the next listener.
+func (t *memTransport) unlisten() {
+	t.mu.Lock()
+	t.port = nil
+	t.mu.Unlock()
+}
+
+func (t *memTransport) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.queue = nil
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// deliver delivers the queued messages until the end is closed.
+func (t *memTransport) deliver() {
+	for {
+		t.mu.Lock()
+		for !t.closed && (t.port == nil || len(t.queue) == 0) {
+			t.cond.Wait()
+		}
+
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+
+		msg, p := t.queue[0], t.port
+		t.queue = t.queue[1:]
+		t.mu.Unlock()
+
+		p.receive(msg)
+	}
+}
+
+// Pipe returns a synchronous duplex MessagePort pipe over an in-memory channel.
+func Pipe() (*MessagePort, *MessagePort) {
+	t1, t2 := newMemTransport(), newMemTransport()
+	t1.peer, t2.peer = t2, t1
+
+	return newMessagePort(t1), newMessagePort(t2)
+}
+
+// bufferSize returns the size of the data in a message to be posted.
+func bufferSize(v any) int {
+	b, _ := v.([]byte)
+	return len(b)
+}
+
+// newArrayBuffer copies b so that the remote side owns the data it receives.
+func newArrayBuffer(b []byte) any {
+	return append([]byte(nil), b...)
+}
+
+// value is a received Go value: nil, a bool, a float64, a string, a []byte,
+// a *memTransport, or a []any or map[string]any of them. Messages written
+// with WriteMessage may hold other values.
+type value = any
+
+// valueKind returns the kind of a received value.
+func valueKind(v any) Kind {
+	switch v.(type) {
+	case nil:
+		return KindNull
+	case bool:
+		return KindBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr, float32, float64:
+		return KindNumber
+	case string:
+		return KindString
+	case []byte:
+		return KindBytes
+	case *memTransport:
+		return KindPort
+	default:
+		return KindObject
+	}
+}
+
+// valueField returns the field key of a map.
+func valueField(v any, key string) (any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		f, ok := v[key]
+		return f, ok
+	default:
+		return nil, false
+	}
+}
+
+func valueBool(v any) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+func valueFloat(v any) float64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	default:
+		return 0
+	}
+}
+
+func valueText(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func valueBytes(v any) []byte {
+	b, _ := v.([]byte)
+	return b
+}
+
+func valuePort(v any) *MessagePort {
+	return newMessagePort(v.(*memTransport))
+}
+
+// newValue copies v like a structured clone, converting numbers to float64
+// and replacing a *MessagePort with its transport. Like js.ValueOf,
+// it fails on other types.
+func newValue(v any) (any, error) {
+	switch x := v.(type) {
+	case nil, bool, string:
+		return x, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr, float32, float64:
+		return valueFloat(x), nil
+	case []byte:
+		return append([]byte(nil), x...), nil
+	case *MessagePort:
+		return transferable(x), nil
+	case []any:
+		s := make([]any, len(x))
+		for i, e := range x {
+			val, err := newValue(e)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = val
+		}
+		return s, nil
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, e := range x {
+			val, err := newValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = val
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("invalid value of type %T", v)
+	}
+}
+
+// transferable stops delivering messages to port so that they are queued
+// for the receiver, and returns its transport.
+func transferable(port *MessagePort) any {
+	t := port.t.(*memTransport)
+	t.unlisten()
+
+	return t
+}
+
+// consoleLog logs to the standard logger.
+func consoleLog(s string) {
+	log.Println(s)
+}
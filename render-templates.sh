@@ -4,7 +4,10 @@ set -eo pipefail
 
 root=$1
 
-wasmExecJSPath="$(go env GOROOT)/misc/wasm/wasm_exec.js"
+wasmExecJSPath="$(go env GOROOT)/lib/wasm/wasm_exec.js"
+if [ ! -f "$wasmExecJSPath" ]; then
+	wasmExecJSPath="$(go env GOROOT)/misc/wasm/wasm_exec.js"
+fi
 
 echo "# Generating public dirs..."
 
@@ -16,5 +19,7 @@ for app in $root/cmdwasm/*/; do
 	mkdir -p "$root/public/$app"
 	cp "$root/third_party/js/stats.min.js" "$root/public/$app/stats.min.js"
 	cat "$wasmExecJSPath" "$root/template/index.js" >"$root/public/$app/index.js"
+	# Loaded by the worker bootstrap scripts generated by wrpc.
+	cp "$wasmExecJSPath" "$root/public/$app/wasm_exec.js"
 	cp "$root/template/index.html" "$root/public/$app/index.html"
 done
@@ -38,8 +38,24 @@ PCMPlayer.prototype.playNext = function (samplesLeft, samplesRight) {
 // self.onload = function () {
 (async function loadAndRunGoWasm() {
   const go = new Go();
+
+  let exitCode = 0;
+  const exit = go.exit;
+  go.exit = (code) => {
+    exitCode = code;
+    exit(code);
+  };
+
   const response = await fetch("main.wasm");
   const buffer = await response.arrayBuffer();
   const result = await WebAssembly.instantiate(buffer, go.importObject);
-  go.run(result.instance);
+  await go.run(result.instance);
+
+  // Report a Go program that crashed in a worker to the main thread
+  // through the Worker error event.
+  if (exitCode !== 0 && typeof WorkerGlobalScope !== "undefined") {
+    setTimeout(() => {
+      throw new Error("Go program exited with code " + exitCode);
+    });
+  }
 })();